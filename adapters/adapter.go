@@ -1,5 +1,7 @@
 package adapters
 
+import "strings"
+
 // Adapter : interface for Logger adapters
 type Adapter interface {
 	Manage([]string, MessageProcessor) error
@@ -11,3 +13,19 @@ type Adapter interface {
 // MessageProcessor : Manage will receive this interface in order to
 // process the input messages
 type MessageProcessor func(string, string) string
+
+// SubjectLevel : level of a message published on the given subject,
+// error for the .error ones and info for the rest
+func SubjectLevel(subject string) string {
+	if strings.Contains(subject, ".error") {
+		return "error"
+	}
+	return "info"
+}
+
+// Private : checks if a message belongs to request/reply traffic that must
+// never be logged. Replies on _INBOX subjects may carry created API tokens
+// or search results, and logger.token requests manage the tokens.
+func Private(subject string) bool {
+	return strings.HasPrefix(subject, "_INBOX.") || strings.HasPrefix(subject, "logger.token.")
+}
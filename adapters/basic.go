@@ -7,53 +7,183 @@ package adapters
 import (
 	"encoding/json"
 	"errors"
-	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ernestio/logger/diag"
 	"github.com/nats-io/go-nats"
 )
 
+// DefaultMaxOpenFiles : routed files kept open when max_open_files is unset
+const DefaultMaxOpenFiles = 64
+
+var errFileClosed = errors.New("File already closed")
+
 // BasicAdapter : Will send logs to a plain file
 type BasicAdapter struct {
-	Type        string               `json:"type"`
-	LogFile     string               `json:"logfile"`
+	Type      string `json:"type"`
+	LogFile   string `json:"logfile"`
+	Route     string `json:"route,omitempty"`
+	Directory string `json:"directory,omitempty"`
+	Chain     bool   `json:"chain,omitempty"`
+	Encrypt   bool   `json:"encrypt,omitempty"`
+	// CheckpointEvery : number of chained lines between signed checkpoints
+	CheckpointEvery int `json:"checkpoint_every,omitempty"`
+	// MaxOpenFiles : number of routed files kept open at once, the least
+	// recently used one is closed when a new file needs to be opened
+	MaxOpenFiles int `json:"max_open_files,omitempty"`
+	FilePermissions
 	Subscribers []*nats.Subscription `json:"-"`
 	Client      *nats.Conn           `json:"-"`
-	File        *os.File             `json:"-"`
+	files       map[string]*basicFile
+	chainKey    []byte
+	sealer      *sealer
+	mu          sync.Mutex
+}
+
+// basicFile : a single file written by the basic adapter
+type basicFile struct {
+	file   *os.File
+	chain  *chain
+	sealer *sealer
+	used   time.Time
+	closed bool
+	mu     sync.Mutex
+}
+
+func (f *basicFile) write(message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return errFileClosed
+	}
+
+	record := time.Now().Format("2006/01/02 15:04:05") + " " + message
+	if f.chain == nil && f.sealer == nil {
+		_, err := f.file.WriteString(record + "\n")
+		return err
+	}
+
+	// chained and encrypted records must stay on a single line
+	record = strings.Replace(record, "\n", "\\n", -1)
+	if f.chain == nil {
+		return f.emit(record)
+	}
+
+	// a file emptied while the chain goes on, as by copytruncate, is
+	// anchored again with a checkpoint
+	if f.chain.seq > 0 {
+		if fi, err := f.file.Stat(); err == nil && fi.Size() == 0 {
+			if err := f.emit(f.chain.checkpoint()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return f.emit(f.chain.next(record)...)
+}
+
+// emit : writes the given lines, sealing them first when encrypted
+func (f *basicFile) emit(lines ...string) error {
+	if f.sealer != nil {
+		for i, line := range lines {
+			sealed, err := f.sealer.seal(line)
+			if err != nil {
+				return err
+			}
+			lines[i] = sealed
+		}
+	}
+
+	_, err := f.file.WriteString(strings.Join(lines, "\n") + "\n")
+	return err
+}
+
+func (f *basicFile) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	if f.chain != nil && f.chain.pending > 0 {
+		if err := f.emit(f.chain.checkpoint()); err != nil {
+			return err
+		}
+	}
+
+	return f.file.Close()
 }
 
 // NewBasicAdapter : Basic adapter constructor
 func NewBasicAdapter(nc *nats.Conn, config []byte) (Adapter, error) {
 	var a BasicAdapter
-	var err error
 
 	if err := json.Unmarshal(config, &a); err != nil {
 		return &a, err
 	}
 
-	if _, err := os.Stat(a.LogFile); os.IsNotExist(err) {
-		return &a, errors.New("Specified file '" + a.LogFile + "' does not exist")
+	if err := a.Validate(); err != nil {
+		return &a, err
 	}
 
-	a.File, err = os.OpenFile(a.LogFile, os.O_WRONLY|os.O_APPEND, 0640)
-	if err != nil {
-		log.Fatalln(err)
-		return &a, errors.New("Seems I don't have permissions to write on " + a.LogFile)
+	if a.MaxOpenFiles < 0 {
+		return &a, errors.New("Invalid max_open_files, it should be a positive number")
+	}
+	if a.MaxOpenFiles == 0 {
+		a.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+
+	if a.Route != "" {
+		if !validRoute(a.Route) {
+			return &a, errors.New("Invalid route '" + a.Route + "'")
+		}
+		if a.Directory == "" {
+			return &a, errors.New("A directory is required to route log files")
+		}
+	}
+
+	if a.Chain {
+		if a.chainKey = []byte(os.Getenv(ChainKeyEnv)); len(a.chainKey) == 0 {
+			return &a, errors.New(ChainKeyEnv + " must be set to sign hash chain checkpoints")
+		}
+	}
+
+	if a.Encrypt {
+		key, err := LoadEncryptionKey()
+		if err != nil {
+			return &a, err
+		}
+		if a.sealer, err = newSealer(key); err != nil {
+			return &a, err
+		}
+	}
+
+	a.files = make(map[string]*basicFile)
+
+	// open the default file up front so any permission problem is reported
+	// to the requester instead of failing on the first log line
+	if _, err := a.open(a.defaultFile()); err != nil {
+		return &a, err
 	}
-	log.SetOutput(io.MultiWriter(a.File, os.Stdout))
 
 	a.Client = nc
-	log.Println("Logger set up")
+	diag.Info("Logger set up")
 
-	return &a, err
+	return &a, nil
 }
 
 // Manage : Manages the subscriptions
 func (l *BasicAdapter) Manage(subjects []string, fn MessageProcessor) (err error) {
 	for _, subject := range subjects {
 		s, _ := l.Client.Subscribe(subject, func(m *nats.Msg) {
-			if m.Subject == "logger.log" {
+			if m.Subject == "logger.log" || Private(m.Subject) {
 				return
 			}
 			l.Log(m.Subject, fn(m.Subject, string(m.Data)), "debug", "system")
@@ -65,25 +195,127 @@ func (l *BasicAdapter) Manage(subjects []string, fn MessageProcessor) (err error
 
 // Log : Writes a log line
 func (l *BasicAdapter) Log(subject, body, level, user string) {
-	log.Println("level=" + level + " user=" + user + " : " + subject + "  '" + body + "'")
+	path := l.target(subject, body, level)
+	line := "level=" + level + " user=" + user + " : " + subject + "  '" + body + "'"
+
+	// a routed file may be closed to make room for another one between
+	// opening and writing it, in which case it is opened again
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := l.open(path)
+		if err != nil {
+			diag.Error(err.Error())
+			return
+		}
+		err = f.write(line)
+		if err == errFileClosed {
+			continue
+		}
+		if err != nil {
+			diag.Error(err.Error())
+		}
+		return
+	}
 }
 
 // Stop : stops current subscriptions
 func (l *BasicAdapter) Stop() {
-	log.Println("Stopping basic logger")
+	diag.Info("Stopping basic logger")
 	for _, s := range l.Subscribers {
 		if err := s.Unsubscribe(); err != nil {
-			log.Println(err.Error())
+			diag.Error(err.Error())
 		}
 	}
-	if err := l.File.Close(); err != nil {
-		log.Println("An error occurred trying to close the file")
-		log.Println(err.Error())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, f := range l.files {
+		if err := f.close(); err != nil {
+			diag.Error("An error occurred trying to close the file")
+			diag.Error(err.Error())
+		}
 	}
-	log.SetOutput(os.Stdout)
+	l.files = make(map[string]*basicFile)
 }
 
 // Name : get the adapter name
 func (l *BasicAdapter) Name() string {
 	return "basic"
 }
+
+// open : returns the file for the given path, opening it if needed
+func (l *BasicAdapter) open(path string) (*basicFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.files[path]; ok {
+		f.used = time.Now()
+		return f, nil
+	}
+
+	l.evict()
+
+	file, err := l.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &basicFile{file: file, sealer: l.sealer, used: time.Now()}
+	if l.Chain {
+		if f.chain, err = newChain(path, l.chainKey, l.CheckpointEvery, l.sealer); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	l.files[path] = f
+
+	return f, nil
+}
+
+// evict : closes the least recently used routed files until there is room
+// for a new one, the default file is always kept open
+func (l *BasicAdapter) evict() {
+	def := l.defaultFile()
+
+	for len(l.files) >= l.MaxOpenFiles {
+		var oldest string
+		for path, f := range l.files {
+			if path == def {
+				continue
+			}
+			if oldest == "" || f.used.Before(l.files[oldest].used) {
+				oldest = path
+			}
+		}
+		if oldest == "" {
+			return
+		}
+
+		if err := l.files[oldest].close(); err != nil {
+			diag.Error(err.Error())
+		}
+		delete(l.files, oldest)
+	}
+}
+
+func (l *BasicAdapter) defaultFile() string {
+	if l.Route == "" {
+		return l.LogFile
+	}
+	if l.LogFile != "" {
+		return filepath.Join(l.Directory, filepath.Base(l.LogFile))
+	}
+	return filepath.Join(l.Directory, unroutedFile)
+}
+
+func (l *BasicAdapter) target(subject, body, level string) string {
+	if l.Route == "" {
+		return l.LogFile
+	}
+
+	name := route(l.Route, subject, body, level)
+	if name == "" {
+		return l.defaultFile()
+	}
+
+	return filepath.Join(l.Directory, name+".log")
+}
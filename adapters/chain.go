@@ -0,0 +1,295 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ChainKeyEnv : environment variable holding the checkpoint signing key
+const ChainKeyEnv = "ERNEST_LOG_CHAIN_KEY"
+
+const defaultCheckpointEvery = 1000
+const checkpointRecord = "CHECKPOINT"
+
+var genesis = strings.Repeat("0", sha256.Size*2)
+
+// chain : keeps the running state of a hash chained log file. Every line
+// is written as "seq=<n> hash=<h> <record>" where h is the SHA-256 of the
+// previous hash, the sequence number and the record.
+type chain struct {
+	key     []byte
+	every   int
+	seq     uint64
+	prev    string
+	pending int
+}
+
+func chainHash(prev string, seq uint64, record string) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, prev+"\n"+strconv.FormatUint(seq, 10)+" "+record)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func checkpointSignature(key []byte, prev string, seq uint64) string {
+	m := hmac.New(sha256.New, key)
+	_, _ = io.WriteString(m, prev+"\n"+strconv.FormatUint(seq, 10))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func formatChainLine(seq uint64, hash, record string) string {
+	return "seq=" + strconv.FormatUint(seq, 10) + " hash=" + hash + " " + record
+}
+
+func parseChainLine(line string) (uint64, string, string, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "seq=") || !strings.HasPrefix(parts[1], "hash=") {
+		return 0, "", "", errors.New("malformed line")
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "seq="), 10, 64)
+	if err != nil {
+		return 0, "", "", errors.New("malformed sequence number")
+	}
+
+	return seq, strings.TrimPrefix(parts[1], "hash="), parts[2], nil
+}
+
+// newChain : creates the chain state for the given file, resuming from
+// its last line when the file already holds records. The sealer is only
+// needed to read back the last line of an encrypted file.
+func newChain(path string, key []byte, every int, s *sealer) (*chain, error) {
+	if every <= 0 {
+		every = defaultCheckpointEvery
+	}
+
+	c := chain{key: key, every: every, prev: genesis}
+
+	last, err := lastLine(path)
+	if err != nil {
+		return nil, err
+	}
+	if last == "" {
+		return &c, nil
+	}
+
+	if s != nil {
+		if last, err = s.open(last); err != nil {
+			return nil, errors.New("Can't resume hash chain on '" + path + "', " + err.Error())
+		}
+	}
+
+	seq, hash, record, err := parseChainLine(last)
+	if err != nil {
+		return nil, errors.New("Can't resume hash chain on '" + path + "', it doesn't look like a chained log")
+	}
+	c.seq = seq
+	c.prev = hash
+
+	// the lines left unsigned by a crash are covered by a checkpoint
+	// right after the next record
+	if _, ok := parseCheckpoint(record); !ok {
+		c.pending = c.every
+	}
+
+	return &c, nil
+}
+
+// next : chains the given record, returning the lines to be written,
+// followed by a signed checkpoint when one is due
+func (c *chain) next(record string) []string {
+	lines := []string{c.append(record)}
+
+	c.pending++
+	if c.pending >= c.every {
+		lines = append(lines, c.checkpoint())
+	}
+
+	return lines
+}
+
+// checkpoint : returns a signed checkpoint covering every line so far.
+// It carries the hash it follows, so a file starting with one, as after
+// a rotation, can be verified from there.
+func (c *chain) checkpoint() string {
+	c.pending = 0
+	sig := checkpointSignature(c.key, c.prev, c.seq+1)
+	return c.append(checkpointRecord + " prev=" + c.prev + " sig=" + sig)
+}
+
+// chainCheckpoint : fields of a checkpoint record. prev is empty on
+// checkpoints written by older versions.
+type chainCheckpoint struct {
+	prev string
+	sig  string
+}
+
+func parseCheckpoint(record string) (chainCheckpoint, bool) {
+	var cp chainCheckpoint
+	if !strings.HasPrefix(record, checkpointRecord+" ") {
+		return cp, false
+	}
+
+	for _, field := range strings.Fields(strings.TrimPrefix(record, checkpointRecord+" ")) {
+		switch {
+		case strings.HasPrefix(field, "prev="):
+			cp.prev = strings.TrimPrefix(field, "prev=")
+		case strings.HasPrefix(field, "sig="):
+			cp.sig = strings.TrimPrefix(field, "sig=")
+		}
+	}
+
+	return cp, true
+}
+
+func (c *chain) append(record string) string {
+	c.seq++
+	c.prev = chainHash(c.prev, c.seq, record)
+	return formatChainLine(c.seq, c.prev, record)
+}
+
+// lastLine : returns the last non empty line of the given file
+func lastLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var buf []byte
+	chunk := int64(4096)
+	for end := fi.Size(); end > 0; {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		b := make([]byte, end-start)
+		if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+			return "", err
+		}
+		buf = append(b, buf...)
+		end = start
+
+		trimmed := strings.TrimRight(string(buf), "\n")
+		if i := strings.LastIndex(trimmed, "\n"); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+
+	return strings.TrimRight(string(buf), "\n"), nil
+}
+
+// ChainReport : result of verifying a hash chained log
+type ChainReport struct {
+	Lines       uint64
+	Checkpoints uint64
+	Unsigned    uint64
+	// First : sequence number the file starts at, above 1 for files
+	// anchored on a checkpoint after a rotation
+	First    uint64
+	Problems []string
+}
+
+// Valid : true when no gaps, reordering or modifications were found
+func (r *ChainReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *ChainReport) problem(line uint64, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+}
+
+// VerifyChain : walks a hash chained log checking sequence numbers,
+// hashes and, when a key is given, checkpoint signatures. With a key,
+// signed checkpoints are also required at least every given number of
+// lines, as they are the only part that can't be forged. A log not
+// starting at sequence 1 must start with a checkpoint, which anchors it.
+func VerifyChain(r io.Reader, key []byte, every int) (*ChainReport, error) {
+	if every <= 0 {
+		every = defaultCheckpointEvery
+	}
+
+	var report ChainReport
+	var seq uint64
+	prev := genesis
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for s.Scan() {
+		report.Lines++
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		n, hash, record, err := parseChainLine(line)
+		if err != nil {
+			report.problem(report.Lines, "%s", err)
+			continue
+		}
+
+		cp, checkpoint := parseCheckpoint(record)
+		if report.First == 0 {
+			report.First = n
+			if n > 1 && checkpoint && cp.prev != "" {
+				seq, prev = n-1, cp.prev
+			}
+		}
+
+		switch {
+		case n == seq+1:
+		case n <= seq:
+			report.problem(report.Lines, "sequence %d found after %d, records were reordered or duplicated", n, seq)
+		default:
+			report.problem(report.Lines, "sequence jumps from %d to %d, %d records are missing", seq, n, n-seq-1)
+		}
+
+		if expected := chainHash(prev, n, record); expected != hash {
+			report.problem(report.Lines, "hash mismatch for sequence %d, the record was modified", n)
+		}
+
+		if checkpoint {
+			report.Checkpoints++
+			if cp.prev != "" && cp.prev != prev {
+				report.problem(report.Lines, "checkpoint for sequence %d doesn't follow the previous line", n)
+			}
+			if key != nil && !hmac.Equal([]byte(cp.sig), []byte(checkpointSignature(key, prev, n))) {
+				report.problem(report.Lines, "invalid checkpoint signature for sequence %d", n)
+			}
+			report.Unsigned = 0
+		} else {
+			report.Unsigned++
+			if key != nil && report.Unsigned == uint64(every)+1 {
+				report.problem(report.Lines, "more than %d lines without a signed checkpoint", every)
+			}
+		}
+
+		seq = n
+		prev = hash
+	}
+
+	if key != nil && report.Checkpoints == 0 && report.First > 0 {
+		report.Problems = append(report.Problems, "no signed checkpoint found")
+	}
+
+	return &report, s.Err()
+}
@@ -0,0 +1,129 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyChain(t *testing.T) {
+	key := []byte("k3y")
+
+	Convey("Given a hash chained log", t, func() {
+		c := chain{key: key, every: 2, prev: genesis}
+		var lines []string
+		for _, r := range []string{"one", "two", "three"} {
+			lines = append(lines, c.next(r)...)
+		}
+		lines = append(lines, c.checkpoint())
+
+		Convey("an untouched log should verify", func() {
+			report, err := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 2)
+			So(err, ShouldBeNil)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Checkpoints, ShouldEqual, 2)
+		})
+
+		Convey("a modified record should be detected", func() {
+			lines[0] = strings.Replace(lines[0], "one", "uno", 1)
+			report, _ := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 2)
+			So(report.Valid(), ShouldBeFalse)
+		})
+
+		Convey("a removed record should be detected", func() {
+			lines = append(lines[:1], lines[2:]...)
+			report, _ := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 2)
+			So(report.Valid(), ShouldBeFalse)
+		})
+
+		Convey("reordered records should be detected", func() {
+			lines[0], lines[1] = lines[1], lines[0]
+			report, _ := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 2)
+			So(report.Valid(), ShouldBeFalse)
+		})
+
+		Convey("a checkpoint signed with another key should be detected", func() {
+			report, _ := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), []byte("other"), 2)
+			So(report.Valid(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a hash chained log with its checkpoints stripped", t, func() {
+		c := chain{key: key, every: 2, prev: genesis}
+		var lines []string
+		for _, r := range []string{"one", "two", "three"} {
+			lines = append(lines, c.append(r))
+		}
+
+		Convey("it should only verify without key", func() {
+			report, _ := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), nil, 2)
+			So(report.Valid(), ShouldBeTrue)
+
+			report, _ = VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 2)
+			So(report.Valid(), ShouldBeFalse)
+
+			report, _ = VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, 10)
+			So(report.Valid(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a hash chained log rotated while it was written", t, func() {
+		c := chain{key: key, every: 2, prev: genesis}
+		for _, r := range []string{"one", "two", "three"} {
+			c.next(r)
+		}
+
+		rotated := []string{c.checkpoint()}
+		for _, r := range []string{"four", "five"} {
+			rotated = append(rotated, c.next(r)...)
+		}
+
+		Convey("the new file should verify from its leading checkpoint", func() {
+			report, err := VerifyChain(strings.NewReader(strings.Join(rotated, "\n")), key, 2)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldBeEmpty)
+			So(report.First, ShouldBeGreaterThan, 1)
+		})
+
+		Convey("it should not verify without its leading checkpoint", func() {
+			report, _ := VerifyChain(strings.NewReader(strings.Join(rotated[1:], "\n")), key, 2)
+			So(report.Valid(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a chained basic adapter whose file is truncated", t, func() {
+		dir, _ := ioutil.TempDir("", "logger")
+		defer func() { _ = os.RemoveAll(dir) }()
+		defer os.Setenv(ChainKeyEnv, os.Getenv(ChainKeyEnv))
+		_ = os.Setenv(ChainKeyEnv, string(key))
+
+		path := filepath.Join(dir, "audit.log")
+		a, err := NewBasicAdapter(nil, []byte(`{"type":"basic","logfile":"`+path+`","chain":true,"checkpoint_every":2}`))
+		So(err, ShouldBeNil)
+
+		for _, r := range []string{"one", "two", "three"} {
+			a.Log("x", r, "info", "system")
+		}
+		So(os.Truncate(path, 0), ShouldBeNil)
+		a.Log("x", "four", "info", "system")
+		a.Stop()
+
+		Convey("the rest of the file should still verify", func() {
+			f, _ := os.Open(path)
+			defer func() { _ = f.Close() }()
+
+			report, err := VerifyChain(f, key, 2)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldBeEmpty)
+			So(report.First, ShouldBeGreaterThan, 1)
+		})
+	})
+}
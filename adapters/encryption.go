@@ -0,0 +1,156 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EncryptionKeyEnv : environment variable holding the log encryption key
+	EncryptionKeyEnv = "ERNEST_LOG_KEY"
+	// EncryptionKeyFileEnv : environment variable pointing to a file
+	// holding the log encryption key
+	EncryptionKeyFileEnv = "ERNEST_LOG_KEY_FILE"
+)
+
+// segmentPrefix : marks an encrypted segment. Every line is sealed on its
+// own, so a file cut on any line boundary, as rotation does, can still be
+// decrypted.
+const segmentPrefix = "enc1:"
+
+// LoadEncryptionKey : reads the AES key from ERNEST_LOG_KEY or, when not
+// set, from the file in ERNEST_LOG_KEY_FILE. Keys can be given as base64
+// or hex and must be 16, 24 or 32 bytes long.
+func LoadEncryptionKey() ([]byte, error) {
+	raw := os.Getenv(EncryptionKeyEnv)
+
+	if raw == "" {
+		path := os.Getenv(EncryptionKeyFileEnv)
+		if path == "" {
+			return nil, errors.New(EncryptionKeyEnv + " or " + EncryptionKeyFileEnv + " must be set to encrypt logs")
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.New("Can't read encryption key file '" + path + "'")
+		}
+		raw = string(data)
+	}
+
+	return decodeKey(strings.TrimSpace(raw))
+}
+
+func decodeKey(raw string) ([]byte, error) {
+	for _, decode := range []func(string) ([]byte, error){hex.DecodeString, base64.StdEncoding.DecodeString} {
+		if key, err := decode(raw); err == nil && validKeySize(len(key)) {
+			return key, nil
+		}
+	}
+	return nil, errors.New("Encryption key must be a base64 or hex encoded 16, 24 or 32 byte key")
+}
+
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// sealer : encrypts and decrypts log lines with AES-GCM
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(key []byte) (*sealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealer{aead: aead}, nil
+}
+
+func (s *sealer) seal(line string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	segment := s.aead.Seal(nonce, nonce, []byte(line), nil)
+
+	return segmentPrefix + base64.StdEncoding.EncodeToString(segment), nil
+}
+
+func (s *sealer) open(line string) (string, error) {
+	if !strings.HasPrefix(line, segmentPrefix) {
+		return "", errors.New("not an encrypted segment")
+	}
+
+	segment, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, segmentPrefix))
+	if err != nil {
+		return "", errors.New("corrupted segment")
+	}
+
+	n := s.aead.NonceSize()
+	if len(segment) < n {
+		return "", errors.New("corrupted segment")
+	}
+
+	plain, err := s.aead.Open(nil, segment[:n], segment[n:], nil)
+	if err != nil {
+		return "", errors.New("segment can't be decrypted with this key")
+	}
+
+	return string(plain), nil
+}
+
+// Decrypt : streams the plain text of an encrypted log to w
+func Decrypt(r io.Reader, w io.Writer, key []byte) error {
+	s, err := newSealer(key)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	bw := bufio.NewWriter(w)
+
+	for sc.Scan() {
+		n++
+		if sc.Text() == "" {
+			continue
+		}
+
+		line, err := s.open(sc.Text())
+		if err != nil {
+			_ = bw.Flush()
+			return errors.New("line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+
+		if _, err := bw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,56 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecrypt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	Convey("Given an encrypted log", t, func() {
+		s, err := newSealer(key)
+		So(err, ShouldBeNil)
+
+		var lines []string
+		for _, l := range []string{"first line", "second line"} {
+			sealed, err := s.seal(l)
+			So(err, ShouldBeNil)
+			So(strings.Contains(sealed, "line"), ShouldBeFalse)
+			lines = append(lines, sealed)
+		}
+		log := strings.Join(lines, "\n") + "\n"
+
+		Convey("it should be decrypted with the same key", func() {
+			var out bytes.Buffer
+			So(Decrypt(strings.NewReader(log), &out, key), ShouldBeNil)
+			So(out.String(), ShouldEqual, "first line\nsecond line\n")
+		})
+
+		Convey("it should fail with a different key", func() {
+			var out bytes.Buffer
+			other := []byte("fedcba9876543210fedcba9876543210")
+			So(Decrypt(strings.NewReader(log), &out, other), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an encoded key", t, func() {
+		Convey("hex and base64 keys should be accepted", func() {
+			_, err := decodeKey("000102030405060708090a0b0c0d0e0f")
+			So(err, ShouldBeNil)
+			_, err = decodeKey("AAECAwQFBgcICQoLDA0ODw==")
+			So(err, ShouldBeNil)
+		})
+		Convey("keys of the wrong size should be rejected", func() {
+			_, err := decodeKey("0001")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,173 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+const defaultFileMode = 0640
+const defaultDirMode = 0755
+
+// FilePermissions : mode and ownership applied to files and directories
+// created by file based adapters
+type FilePermissions struct {
+	FileMode string `json:"file_mode,omitempty"`
+	DirMode  string `json:"dir_mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+}
+
+func parseMode(mode string, def os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return def, errors.New("Invalid file mode '" + mode + "'")
+	}
+	return os.FileMode(m), nil
+}
+
+func (p *FilePermissions) fileMode() (os.FileMode, error) {
+	return parseMode(p.FileMode, defaultFileMode)
+}
+
+func (p *FilePermissions) dirMode() (os.FileMode, error) {
+	return parseMode(p.DirMode, defaultDirMode)
+}
+
+// ids : resolves the configured owner and group, -1 means unchanged
+func (p *FilePermissions) ids() (int, int, error) {
+	uid, gid := -1, -1
+
+	if p.Owner != "" {
+		u, err := user.Lookup(p.Owner)
+		if err != nil {
+			u, err = user.LookupId(p.Owner)
+		}
+		if err != nil {
+			return uid, gid, errors.New("Unknown owner '" + p.Owner + "'")
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+
+	if p.Group != "" {
+		g, err := user.LookupGroup(p.Group)
+		if err != nil {
+			g, err = user.LookupGroupId(p.Group)
+		}
+		if err != nil {
+			return uid, gid, errors.New("Unknown group '" + p.Group + "'")
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+// Validate : checks the configured modes and owners are usable
+func (p *FilePermissions) Validate() error {
+	if _, err := p.fileMode(); err != nil {
+		return err
+	}
+	if _, err := p.dirMode(); err != nil {
+		return err
+	}
+	_, _, err := p.ids()
+	return err
+}
+
+func (p *FilePermissions) mkdirAll(dir string) error {
+	mode, err := p.dirMode()
+	if err != nil {
+		return err
+	}
+
+	uid, gid, err := p.ids()
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for d := dir; d != "." && d != string(filepath.Separator); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+	}
+
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return errors.New("Could not create directory '" + dir + "'")
+	}
+
+	// the requested mode is applied explicitly as MkdirAll honours umask
+	for _, d := range missing {
+		if err := os.Chmod(d, mode); err != nil {
+			return errors.New("Could not change mode of '" + d + "'")
+		}
+		if uid == -1 && gid == -1 {
+			continue
+		}
+		if err := os.Chown(d, uid, gid); err != nil {
+			return errors.New("Could not change ownership of '" + d + "'")
+		}
+	}
+
+	return nil
+}
+
+// OpenFile : opens the given path for appending, creating any missing
+// parent directories and the file itself with the configured permissions
+func (p *FilePermissions) OpenFile(path string) (*os.File, error) {
+	if path == "" {
+		return nil, errors.New("No log file specified")
+	}
+
+	if err := p.mkdirAll(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	mode, err := p.fileMode()
+	if err != nil {
+		return nil, err
+	}
+
+	uid, gid, err := p.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	_, statErr := os.Stat(path)
+	created := os.IsNotExist(statErr)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, errors.New("Seems I don't have permissions to write on " + path)
+	}
+
+	if !created {
+		return f, nil
+	}
+
+	// the requested mode is applied explicitly as OpenFile honours umask
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return nil, errors.New("Could not change mode of '" + path + "'")
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := f.Chown(uid, gid); err != nil {
+			_ = f.Close()
+			return nil, errors.New("Could not change ownership of '" + path + "'")
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenFile(t *testing.T) {
+	Convey("Given a log file on a path that does not exist", t, func() {
+		dir, _ := ioutil.TempDir("", "logger")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, "a", "b", "ernest.log")
+
+		Convey("it should create the directories and the file with the given mode", func() {
+			p := FilePermissions{FileMode: "0600", DirMode: "0700"}
+			f, err := p.OpenFile(path)
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			fi, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+			di, err := os.Stat(filepath.Join(dir, "a"))
+			So(err, ShouldBeNil)
+			So(di.Mode().Perm(), ShouldEqual, os.FileMode(0700))
+		})
+
+		Convey("it should apply the directory mode regardless of umask", func() {
+			old := syscall.Umask(0077)
+			defer syscall.Umask(old)
+
+			p := FilePermissions{FileMode: "0640", DirMode: "0750"}
+			f, err := p.OpenFile(path)
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			for _, d := range []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "b")} {
+				di, err := os.Stat(d)
+				So(err, ShouldBeNil)
+				So(di.Mode().Perm(), ShouldEqual, os.FileMode(0750))
+			}
+		})
+
+		Convey("it should reject an invalid mode", func() {
+			p := FilePermissions{FileMode: "rw"}
+			So(p.Validate(), ShouldNotBeNil)
+		})
+	})
+}
@@ -7,12 +7,11 @@ package adapters
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/ernestio/logger/diag"
 	"github.com/nats-io/go-nats"
 )
 
@@ -45,7 +44,7 @@ func NewLogstashAdapter(nc *nats.Conn, config []byte) (Adapter, error) {
 
 	go func() {
 		if err := l.writeln([]byte(`{"service":"initial"}`)); err != nil {
-			log.Println(err.Error())
+			diag.Error(err.Error())
 		}
 	}()
 
@@ -56,7 +55,7 @@ func NewLogstashAdapter(nc *nats.Conn, config []byte) (Adapter, error) {
 func (l *LogstashAdapter) Manage(subjects []string, fn MessageProcessor) (err error) {
 	for _, subject := range subjects {
 		s, _ := l.Client.Subscribe(subject, func(m *nats.Msg) {
-			if m.Subject == "logger.log" {
+			if m.Subject == "logger.log" || Private(m.Subject) {
 				return
 			}
 			l.Log(m.Subject, fn(m.Subject, string(m.Data)), "debug", "system")
@@ -74,10 +73,10 @@ func (l *LogstashAdapter) Log(subject, body, level, user string) {
 		Message: body,
 	}
 	if body, err := json.Marshal(lg); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	} else {
 		if err = l.writeln(body); err != nil {
-			log.Println(err.Error())
+			diag.Error(err.Error())
 		}
 	}
 }
@@ -86,7 +85,7 @@ func (l *LogstashAdapter) Log(subject, body, level, user string) {
 func (l *LogstashAdapter) Stop() {
 	for _, s := range l.Subscribers {
 		if err := s.Unsubscribe(); err != nil {
-			log.Println(err.Error())
+			diag.Error(err.Error())
 		}
 	}
 }
@@ -94,8 +93,8 @@ func (l *LogstashAdapter) Stop() {
 func (l *LogstashAdapter) writeln(message []byte) (err error) {
 	port := strconv.Itoa(l.Port)
 	url := "http://" + l.Hostname + ":" + port
-	println(string(url))
-	println(string(message))
+	diag.Debug(url)
+	diag.Debug(string(message))
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(message))
 	req.Header.Set("Content-Type", "application/json")
 
@@ -105,10 +104,10 @@ func (l *LogstashAdapter) writeln(message []byte) (err error) {
 		return err
 	}
 
-	fmt.Println("response Status:", resp.Status)
-	fmt.Println("response Headers:", resp.Header)
+	diag.Debug("response Status:", resp.Status)
+	diag.Debug("response Headers:", resp.Header)
 	body, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println("response Body:", string(body))
+	diag.Debug("response Body:", string(body))
 
 	if err := resp.Body.Close(); err != nil {
 		return err
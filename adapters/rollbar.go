@@ -6,10 +6,9 @@ package adapters
 
 import (
 	"encoding/json"
-	"log"
 	"os"
-	"strings"
 
+	"github.com/ernestio/logger/diag"
 	"github.com/nats-io/go-nats"
 	"github.com/stvp/rollbar"
 )
@@ -34,7 +33,7 @@ func NewRollbarAdapter(nc *nats.Conn, config []byte) (Adapter, error) {
 	}
 
 	a.Client = nc
-	log.Println("Logger set up")
+	diag.Info("Logger set up")
 
 	return &a, err
 }
@@ -46,14 +45,10 @@ func (l *RollbarAdapter) Manage(subjects []string, fn MessageProcessor) (err err
 
 	for _, subject := range subjects {
 		s, _ := l.Client.Subscribe(subject, func(m *nats.Msg) {
-			if m.Subject == "logger.log" {
+			if m.Subject == "logger.log" || Private(m.Subject) {
 				return
 			}
-			level := "info"
-			if strings.Contains(subject, ".error") {
-				level = "error"
-			}
-			l.Log(m.Subject, fn(m.Subject, string(m.Data)), level, "system")
+			l.Log(m.Subject, fn(m.Subject, string(m.Data)), SubjectLevel(m.Subject), "system")
 		})
 		l.Subscribers = append(l.Subscribers, s)
 	}
@@ -67,10 +62,10 @@ func (l *RollbarAdapter) Log(subject, body, level, user string) {
 
 // Stop : stops current subscriptions
 func (l *RollbarAdapter) Stop() {
-	log.Println("Stopping rollbar logger")
+	diag.Info("Stopping rollbar logger")
 	for _, s := range l.Subscribers {
 		if err := s.Unsubscribe(); err != nil {
-			log.Println(err.Error())
+			diag.Error(err.Error())
 		}
 	}
 }
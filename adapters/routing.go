@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// RouteLevel : splits records into errors.log and one file per level
+	RouteLevel = "level"
+	// RouteSubject : one file per top-level subject token
+	RouteSubject = "subject"
+	// RouteService : one file per service or build ID
+	RouteService = "service"
+)
+
+// unroutedFile : receives any record a route can't place
+const unroutedFile = "ernest.log"
+
+// serviceKeys : body fields holding the service or build a message belongs to
+var serviceKeys = []string{"build_id", "service_id", "service"}
+
+func validRoute(r string) bool {
+	return r == RouteLevel || r == RouteSubject || r == RouteService
+}
+
+// route : returns the file name, without extension, a record should be
+// written to, or an empty string when it can't be decided
+func route(r, subject, body, level string) string {
+	switch r {
+	case RouteLevel:
+		if level == "error" || strings.Contains(subject, ".error") {
+			return "errors"
+		}
+		return sanitize(level)
+	case RouteSubject:
+		return sanitize(strings.Split(subject, ".")[0])
+	case RouteService:
+		if ids := ServiceIDs(body); len(ids) > 0 {
+			return sanitize(ids[0])
+		}
+	}
+	return ""
+}
+
+// ServiceIDs : returns the build and service IDs found on a message body,
+// most specific first
+func ServiceIDs(body string) []string {
+	var ids []string
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return ids
+	}
+
+	for _, k := range serviceKeys {
+		if v, ok := m[k].(string); ok && v != "" {
+			ids = append(ids, v)
+		}
+	}
+
+	return ids
+}
+
+// sanitize : makes sure a value taken from a message is a safe file name
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+
+	if len(s) > 128 {
+		s = s[:128]
+	}
+
+	return s
+}
@@ -0,0 +1,74 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoute(t *testing.T) {
+	Convey("Given a record routed by level", t, func() {
+		Convey("error subjects should go to the errors file", func() {
+			So(route(RouteLevel, "instance.create.aws.error", "{}", "debug"), ShouldEqual, "errors")
+		})
+		Convey("other subjects should go to their level file", func() {
+			So(route(RouteLevel, "instance.create.aws", "{}", "debug"), ShouldEqual, "debug")
+		})
+	})
+
+	Convey("Given a record routed by subject", t, func() {
+		So(route(RouteSubject, "network.create.aws.done", "{}", "debug"), ShouldEqual, "network")
+	})
+
+	Convey("Given a record routed by service", t, func() {
+		Convey("the build id should be used when present", func() {
+			So(route(RouteService, "x", `{"build_id":"b-1","service":"s-1"}`, "debug"), ShouldEqual, "b-1")
+		})
+		Convey("unsafe characters should be removed", func() {
+			So(route(RouteService, "x", `{"service":"../../etc/passwd"}`, "debug"), ShouldEqual, "______etc_passwd")
+		})
+		Convey("records without a service should not be routed", func() {
+			So(route(RouteService, "x", `not json`, "debug"), ShouldEqual, "")
+		})
+	})
+}
+
+func TestRoutedFiles(t *testing.T) {
+	Convey("Given a basic adapter routing to more files than it keeps open", t, func() {
+		dir, _ := ioutil.TempDir("", "logger")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		a, err := NewBasicAdapter(nil, []byte(`{"type":"basic","route":"subject","directory":"`+dir+`","max_open_files":2}`))
+		So(err, ShouldBeNil)
+		l := a.(*BasicAdapter)
+		defer l.Stop()
+
+		for _, subject := range []string{"instance.create", "network.create", "firewall.create", "instance.delete"} {
+			l.Log(subject, "{}", "debug", "system")
+		}
+
+		Convey("it should close the least recently used files", func() {
+			So(len(l.files), ShouldBeLessThanOrEqualTo, 2)
+			So(l.files, ShouldContainKey, filepath.Join(dir, "ernest.log"))
+		})
+
+		Convey("it should keep appending to files opened again", func() {
+			data, err := ioutil.ReadFile(filepath.Join(dir, "instance.log"))
+			So(err, ShouldBeNil)
+			So(strings.Count(string(data), "\n"), ShouldEqual, 2)
+
+			for _, name := range []string{"network.log", "firewall.log"} {
+				_, err := os.Stat(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+}
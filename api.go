@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ernestio/logger/diag"
+)
+
+// adaptersHandler : manages the adapters over http with an admin token,
+// as logger.find, logger.set and logger.del do over nats
+//
+//	GET    /adapters         active adapters
+//	GET    /adapters/{name}  a single adapter
+//	PUT    /adapters/{name}  creates or replaces an adapter
+//	DELETE /adapters/{name}  stops an adapter
+func adaptersHandler(w http.ResponseWriter, r *http.Request) {
+	username, status, err := authorize(w, r)
+	if err != nil {
+		apiError(w, status, err)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/adapters"), "/")
+	if strings.Contains(name, "/") {
+		apiError(w, http.StatusNotFound, errAdapterNotFound)
+		return
+	}
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		writeJSON(w, activeAdapters())
+		return
+	}
+
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		if err := permitChange(requestToken(r), username, r.Method+" /adapters", name); err != nil {
+			apiError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a, err := findAdapter(name)
+		if err != nil {
+			adapterError(w, err)
+			return
+		}
+		writeJSON(w, a)
+	case http.MethodPut:
+		config, err := adapterConfig(name, r.Body)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		a, err := setAdapter(config)
+		if err != nil {
+			adapterError(w, err)
+			return
+		}
+		diag.Info("Logger " + name + " set by " + username)
+		writeJSON(w, a)
+	case http.MethodDelete:
+		if err := deleteAdapter(name); err != nil {
+			adapterError(w, err)
+			return
+		}
+		diag.Info("Logger " + name + " deleted by " + username)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+// adapterConfig : reads the config sent to PUT /adapters/{name}, which
+// gets its type from the path
+func adapterConfig(name string, body io.Reader) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(body, maxMessageSize)).Decode(&config); err != nil {
+		return nil, errors.New("Invalid logger config")
+	}
+
+	if t, ok := config["type"]; ok && t != name {
+		return nil, errors.New("Logger type doesn't match '" + name + "'")
+	}
+	config["type"] = name
+
+	return json.Marshal(config)
+}
+
+// adapterError : maps the errors of the adapter management to a status
+func adapterError(w http.ResponseWriter, err error) {
+	switch err {
+	case errInvalidAdapter, errAdapterNotFound:
+		apiError(w, http.StatusNotFound, err)
+	case errBasicRequired:
+		apiError(w, http.StatusConflict, err)
+	default:
+		apiError(w, http.StatusBadRequest, err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	apiError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+}
@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	ads "github.com/ernestio/logger/adapters"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdaptersHandler(t *testing.T) {
+	Convey("Given the adapters api", t, func() {
+		verifier = &Verifier{Algorithms: []string{"HS256"}, secret: []byte("test")}
+		adapters = make(map[string]ads.Adapter)
+		defer func() { verifier = nil }()
+
+		exp := float64(time.Now().Add(time.Hour).Unix())
+		admin := signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp, "admin": true, "username": "admin"})
+		change := func() string {
+			jti, _ := randomHex(8)
+			return signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{
+				"exp": float64(time.Now().Add(time.Minute).Unix()), "jti": jti,
+				"admin": true, "username": "admin",
+			})
+		}
+
+		call := func(method, path, token, body string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, path, strings.NewReader(body))
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			adaptersHandler(w, r)
+			return w
+		}
+
+		Convey("requests without an admin token should be refused", func() {
+			w := call("GET", "/adapters", "", "")
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Body.String(), ShouldContainSubstring, `"error"`)
+		})
+
+		Convey("it should list the active adapters", func() {
+			w := call("GET", "/adapters", admin, "")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(strings.TrimSpace(w.Body.String()), ShouldEqual, "[]")
+		})
+
+		Convey("it should answer with json errors", func() {
+			So(call("GET", "/adapters/logstash", admin, "").Code, ShouldEqual, http.StatusNotFound)
+			So(call("PUT", "/adapters/unknown", change(), `{}`).Code, ShouldEqual, http.StatusNotFound)
+			So(call("PUT", "/adapters/logstash", change(), `{"type":"rollbar"}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(call("PUT", "/adapters/logstash", change(), `not json`).Code, ShouldEqual, http.StatusBadRequest)
+			So(call("DELETE", "/adapters/basic", change(), "").Code, ShouldEqual, http.StatusConflict)
+			So(call("DELETE", "/adapters/rollbar", change(), "").Code, ShouldEqual, http.StatusNotFound)
+			So(call("POST", "/adapters/rollbar", admin, "").Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("changes should need a single use token", func() {
+			So(call("DELETE", "/adapters/rollbar", admin, "").Code, ShouldEqual, http.StatusForbidden)
+
+			token := change()
+			So(call("DELETE", "/adapters/rollbar", token, "").Code, ShouldEqual, http.StatusNotFound)
+			So(call("DELETE", "/adapters/rollbar", token, "").Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("API tokens should not change the adapters", func() {
+			dir, _ := ioutil.TempDir("", "tokens")
+			defer func() { _ = os.RemoveAll(dir) }()
+			previousTokens := apitokens
+			defer func() { apitokens = previousTokens }()
+
+			var err error
+			apitokens, err = OpenTokenStore(filepath.Join(dir, ".tokens"))
+			So(err, ShouldBeNil)
+			created, err := apitokens.Create(APIToken{Name: "siem", Admin: true})
+			So(err, ShouldBeNil)
+
+			So(call("GET", "/adapters", created.Token, "").Code, ShouldEqual, http.StatusOK)
+
+			w := call("PUT", "/adapters/logstash", created.Token, `{"hostname":"http://logstash","port":2234}`)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(w.Body.String(), ShouldContainSubstring, errAPITokenChange.Error())
+			So(call("DELETE", "/adapters/logstash", created.Token, "").Code, ShouldEqual, http.StatusForbidden)
+			So(adapters, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a running basic adapter", t, func() {
+		dir, _ := ioutil.TempDir("", "logger")
+		defer func() { _ = os.RemoveAll(dir) }()
+		defer os.Setenv("ERNEST_LOG_CONFIG", os.Getenv("ERNEST_LOG_CONFIG"))
+		_ = os.Setenv("ERNEST_LOG_CONFIG", dir+"/")
+
+		adapters = make(map[string]ads.Adapter)
+		logfile := filepath.Join(dir, "ernest.log")
+		_, err := setAdapter([]byte(`{"type":"basic","logfile":"` + logfile + `"}`))
+		So(err, ShouldBeNil)
+		defer stopAdapter("basic")
+
+		Convey("an invalid replacement should leave it running", func() {
+			_, err := setAdapter([]byte(`{"type":"basic","logfile":"` + logfile + `","file_mode":"rw"}`))
+			So(err, ShouldNotBeNil)
+
+			a, err := findAdapter("basic")
+			So(err, ShouldBeNil)
+			So(a.(*ads.BasicAdapter).LogFile, ShouldEqual, logfile)
+
+			a.Log("instance.create", "{}", "info", "system")
+			data, _ := ioutil.ReadFile(logfile)
+			So(string(data), ShouldContainSubstring, "instance.create")
+		})
+	})
+
+	Convey("Given the config sent to create an adapter", t, func() {
+		Convey("its type should be taken from the path", func() {
+			config, err := adapterConfig("logstash", strings.NewReader(`{"hostname":"http://logstash","port":2234}`))
+			So(err, ShouldBeNil)
+			So(string(config), ShouldContainSubstring, `"type":"logstash"`)
+		})
+	})
+}
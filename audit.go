@@ -0,0 +1,235 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+// defaultAuditSize : audit records kept in memory to answer queries
+const defaultAuditSize = 1000
+
+const (
+	// AuditDenied : a connection was refused, before or after authenticating
+	AuditDenied = "denied"
+	// AuditConnect : a viewer authenticated and started following the logs
+	AuditConnect = "connect"
+	// AuditDisconnect : a viewer went away
+	AuditDisconnect = "disconnect"
+	// AuditControl : a control request was accepted
+	AuditControl = "control"
+	// AuditRejected : a control request was refused
+	AuditRejected = "rejected"
+)
+
+// AuditRecord : an attempt to follow the live logs, or to change the
+// logger's configuration, published on logger.audit.<event>
+type AuditRecord struct {
+	Event        string     `json:"event"`
+	Time         time.Time  `json:"time"`
+	Username     string     `json:"username,omitempty"`
+	RemoteAddr   string     `json:"remote_addr"`
+	Transport    string     `json:"transport"`
+	ViewerID     string     `json:"viewer_id,omitempty"`
+	Filter       *Filter    `json:"filter,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	Action       string     `json:"action,omitempty"`
+	Connected    *time.Time `json:"connected,omitempty"`
+	Disconnected *time.Time `json:"disconnected,omitempty"`
+	BytesSent    int64      `json:"bytes_sent,omitempty"`
+	Skipped      int64      `json:"skipped,omitempty"`
+}
+
+// AuditQuery : criteria sent on logger.audits, all of them optional
+type AuditQuery struct {
+	Event      string    `json:"event,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	From       time.Time `json:"from,omitempty"`
+	To         time.Time `json:"to,omitempty"`
+	Limit      int       `json:"limit,omitempty"`
+}
+
+// Audit : keeps the last audit records and publishes every new one, so
+// they are written by the adapters like any other message
+type Audit struct {
+	records []AuditRecord
+	size    int
+	nc      *nats.Conn
+	mu      sync.RWMutex
+}
+
+// NewAudit : Audit constructor, publishing on the given connection
+func NewAudit(nc *nats.Conn, size int) *Audit {
+	if size <= 0 {
+		size = defaultAuditSize
+	}
+	return &Audit{nc: nc, size: size}
+}
+
+// newAuditFromEnv : keeps up to ERNEST_AUDIT_SIZE records in memory
+func newAuditFromEnv(nc *nats.Conn) *Audit {
+	size, _ := strconv.Atoi(os.Getenv("ERNEST_AUDIT_SIZE"))
+	return NewAudit(nc, size)
+}
+
+// Record : keeps and publishes an audit record
+func (a *Audit) Record(r AuditRecord) {
+	if a == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	a.mu.Lock()
+	a.records = append(a.records, r)
+	if len(a.records) > a.size {
+		a.records = a.records[len(a.records)-a.size:]
+	}
+	a.mu.Unlock()
+
+	if a.nc == nil {
+		return
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		diag.Error(err.Error())
+		return
+	}
+	if err := a.nc.Publish("logger.audit."+r.Event, data); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+// Find : returns, oldest first, the last records matching the query
+func (a *Audit) Find(q *AuditQuery) []AuditRecord {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matches := make([]AuditRecord, 0)
+	for i := len(a.records) - 1; i >= 0; i-- {
+		r := a.records[i]
+		if !q.match(&r) {
+			continue
+		}
+		matches = append(matches, r)
+		if q.Limit > 0 && len(matches) == q.Limit {
+			break
+		}
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	return matches
+}
+
+func (q *AuditQuery) match(r *AuditRecord) bool {
+	if q.Event != "" && q.Event != r.Event {
+		return false
+	}
+	if q.Username != "" && q.Username != r.Username {
+		return false
+	}
+	if q.RemoteAddr != "" && q.RemoteAddr != r.RemoteAddr && q.RemoteAddr != host(r.RemoteAddr) {
+		return false
+	}
+	if !q.From.IsZero() && r.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.Time.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// host : strips the port from a remote address
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// auditDenied : records a refused connection, s being the session sent
+// by the client if it got that far
+func auditDenied(r *http.Request, transport, reason string, s *Session) {
+	auditRefused(r, transport, reason, s, 0)
+}
+
+// auditRefused : records a refused connection, along with the number of
+// refusals of its address for the same reason left out since the last
+// one recorded
+func auditRefused(r *http.Request, transport, reason string, s *Session, skipped int64) {
+	a := AuditRecord{
+		Event:      AuditDenied,
+		RemoteAddr: r.RemoteAddr,
+		Transport:  transport,
+		Reason:     reason,
+		Skipped:    skipped,
+	}
+	if s != nil {
+		f := s.Filter
+		a.Username = s.Username
+		a.Filter = &f
+	}
+	audit.Record(a)
+}
+
+func auditConnected(v *Viewer, s *Session) {
+	f := s.Filter
+	audit.Record(AuditRecord{
+		Event:      AuditConnect,
+		Username:   v.Username,
+		RemoteAddr: v.RemoteAddr,
+		Transport:  v.Transport,
+		ViewerID:   v.ID,
+		Filter:     &f,
+		Connected:  &v.Connected,
+	})
+}
+
+// auditDisconnected : records the end of a connection, once the viewer
+// left the hub so its eviction reason can't change anymore
+func auditDisconnected(v *Viewer, s *Session, sent int64) {
+	f := s.Filter
+	now := time.Now().UTC()
+	audit.Record(AuditRecord{
+		Event:        AuditDisconnect,
+		Username:     v.Username,
+		RemoteAddr:   v.RemoteAddr,
+		Transport:    v.Transport,
+		ViewerID:     v.ID,
+		Filter:       &f,
+		Reason:       v.reason,
+		Connected:    &v.Connected,
+		Disconnected: &now,
+		BytesSent:    sent,
+	})
+}
+
+var auditsListener = func(m *nats.Msg) {
+	var q AuditQuery
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &q); err != nil {
+			invalidRequest(m, err)
+			return
+		}
+	}
+
+	reply(m, audit.Find(&q), nil)
+}
@@ -0,0 +1,36 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+	Convey("Given an audit keeping three records", t, func() {
+		a := NewAudit(nil, 3)
+		a.Record(AuditRecord{Event: AuditDenied, RemoteAddr: "10.0.0.9:4000", Reason: "Unauthorized"})
+		a.Record(AuditRecord{Event: AuditConnect, Username: "alice", RemoteAddr: "10.0.0.1:1234"})
+		a.Record(AuditRecord{Event: AuditConnect, Username: "bob", RemoteAddr: "10.0.0.2:1234"})
+		a.Record(AuditRecord{Event: AuditDisconnect, Username: "alice", RemoteAddr: "10.0.0.1:1234", BytesSent: 2048})
+
+		Convey("only the last records should be kept, oldest first", func() {
+			records := a.Find(&AuditQuery{})
+			So(len(records), ShouldEqual, 3)
+			So(records[0].Username, ShouldEqual, "alice")
+			So(records[2].Event, ShouldEqual, AuditDisconnect)
+			So(records[2].Time.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("they should be found by user, event and address", func() {
+			So(len(a.Find(&AuditQuery{Username: "alice"})), ShouldEqual, 2)
+			So(len(a.Find(&AuditQuery{Event: AuditConnect})), ShouldEqual, 2)
+			So(len(a.Find(&AuditQuery{RemoteAddr: "10.0.0.2"})), ShouldEqual, 1)
+			So(len(a.Find(&AuditQuery{Username: "alice", Limit: 1})), ShouldEqual, 1)
+		})
+	})
+}
@@ -6,11 +6,9 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
+	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/websocket"
 )
 
@@ -19,13 +17,22 @@ type Session struct {
 	Token         string  `json:"token"`
 	Stream        *string `json:"stream"`
 	EventID       *string `json:"event_id"`
+	Filter        Filter  `json:"filter"`
+	Backlog       int     `json:"backlog"`
 	Username      string
 	Authenticated bool
+	Identity      *Identity `json:"-"`
+}
+
+// Match : checks the session filter selects the given message and the
+// session is allowed to see it
+func (s *Session) Match(m *LogMessage) bool {
+	return s.Filter.Match(m) && s.Identity.Allows(m)
 }
 
 func unauthorized(mt int, c *websocket.Conn) error {
 	_ = c.WriteMessage(mt, []byte(`{"status": "unauthorized"}`))
-	return errors.New("Unauthorized")
+	return errUnauthorized
 }
 
 func authenticate(w http.ResponseWriter, c *websocket.Conn) (*Session, error) {
@@ -41,25 +48,13 @@ func authenticate(w http.ResponseWriter, c *websocket.Conn) (*Session, error) {
 		return nil, badrequest(w)
 	}
 
-	token, err := jwt.Parse(s.Token, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("unexpected jwt signing method=%v", t.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
-
-	if err != nil || !token.Valid {
-		return nil, unauthorized(mt, c)
+	// the refused session is still returned so the attempt can be audited
+	s.Identity, err = parseToken(s.Token)
+	if err != nil {
+		return &s, unauthorized(mt, c)
 	}
-
+	s.Username = s.Identity.Username
 	s.Authenticated = true
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok {
-		if claims["admin"].(bool) != true {
-			return nil, unauthorized(mt, c)
-		}
-		s.Username = claims["username"].(string)
-	}
 
 	err = c.WriteMessage(mt, []byte(`{"status": "ok"}`))
 	if err != nil {
@@ -68,3 +63,41 @@ func authenticate(w http.ResponseWriter, c *websocket.Conn) (*Session, error) {
 
 	return &s, nil
 }
+
+// validateToken : checks the given jwt belongs to an admin, returning
+// its username
+func validateToken(t string) (string, error) {
+	id, err := parseToken(t)
+	if err != nil || !id.Admin {
+		return "", errUnauthorized
+	}
+
+	return id.Username, nil
+}
+
+// parseToken : validates the given jwt or API token, returning the
+// identity of its owner, either an admin or a user scoped by its claims
+func parseToken(t string) (*Identity, error) {
+	if strings.HasPrefix(t, apiTokenPrefix) {
+		if apitokens == nil {
+			return nil, errUnauthorized
+		}
+		return apitokens.Identify(t)
+	}
+
+	claims, err := verifier.Parse(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return identityFromClaims(claims)
+}
+
+// requestToken : gets the jwt sent on a plain http request, either as a
+// bearer token or as the token query parameter
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBufferSize = 1000
+const defaultBufferMemory = 16 * 1024 * 1024
+
+// recordOverhead : rough size of a record besides its strings
+const recordOverhead = 64
+
+var errInvalidEventID = errors.New("Invalid event id")
+
+// Record : an obfuscated log record as kept by the logger
+type Record struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// EventID : the ID prefixed with the buffer epoch, as clients should
+	// send it back when resuming
+	EventID string `json:"event_id,omitempty"`
+	// Historical : set on records sent from the buffer instead of live
+	Historical bool `json:"historical,omitempty"`
+	LogMessage
+}
+
+func (r *Record) size() int {
+	return recordOverhead + len(r.Subject) + len(r.Message) + len(r.Body) + len(r.Level) + len(r.User)
+}
+
+// RingBuffer : keeps the last records in memory, bounded both by number
+// of records and by their approximate memory usage
+type RingBuffer struct {
+	// epoch : set on start, so IDs handed out by a previous instance are
+	// not mistaken for the ones restarting from 1
+	epoch   string
+	records []Record
+	start   int
+	count   int
+	bytes   int
+	memory  int
+	lastID  uint64
+	mu      sync.RWMutex
+}
+
+// NewRingBuffer : RingBuffer constructor
+func NewRingBuffer(size, memory int) *RingBuffer {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	if memory <= 0 {
+		memory = defaultBufferMemory
+	}
+
+	return &RingBuffer{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		records: make([]Record, size),
+		memory:  memory,
+	}
+}
+
+// newRingBufferFromEnv : sizes the buffer from the <prefix>_SIZE and
+// <prefix>_MEMORY environment variables
+func newRingBufferFromEnv(prefix string) *RingBuffer {
+	size, _ := strconv.Atoi(os.Getenv(prefix + "_SIZE"))
+	memory, _ := strconv.Atoi(os.Getenv(prefix + "_MEMORY"))
+	return NewRingBuffer(size, memory)
+}
+
+// Add : stores a new record, evicting the oldest ones when full, and
+// returns it with its ID and time set
+func (b *RingBuffer) Add(m LogMessage) Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	r := Record{ID: b.lastID, Time: time.Now().UTC(), LogMessage: m}
+	r.EventID = b.epoch + "-" + strconv.FormatUint(r.ID, 10)
+
+	if b.count == len(b.records) {
+		b.evict()
+	}
+	b.records[(b.start+b.count)%len(b.records)] = r
+	b.count++
+	b.bytes += r.size()
+
+	for b.bytes > b.memory && b.count > 1 {
+		b.evict()
+	}
+
+	return r
+}
+
+func (b *RingBuffer) evict() {
+	b.bytes -= b.records[b.start].size()
+	b.records[b.start] = Record{}
+	b.start = (b.start + 1) % len(b.records)
+	b.count--
+}
+
+// Query : returns, oldest first, the last records matching the filter
+func (b *RingBuffer) Query(f *Filter) []Record {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	matches := make([]Record, 0)
+	for i := b.count - 1; i >= 0; i-- {
+		r := b.records[(b.start+i)%len(b.records)]
+		if !f.Since.IsZero() && r.Time.Before(f.Since) {
+			break
+		}
+		if !f.Match(&r.LogMessage) {
+			continue
+		}
+		matches = append(matches, r)
+		if f.Limit > 0 && len(matches) == f.Limit {
+			break
+		}
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	return matches
+}
+
+// After : returns, oldest first, the records following the given ID. gap
+// is set when some of them are no longer retained, or the ID is unknown,
+// as after a restart.
+func (b *RingBuffer) After(id uint64) (records []Record, gap bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if id > b.lastID {
+		gap = true
+		id = 0
+	}
+
+	records = make([]Record, 0)
+	for i := 0; i < b.count; i++ {
+		r := b.records[(b.start+i)%len(b.records)]
+		if r.ID <= id {
+			continue
+		}
+		if len(records) == 0 && r.ID > id+1 {
+			gap = true
+		}
+		records = append(records, r)
+	}
+
+	if len(records) == 0 && b.lastID > id {
+		gap = true
+	}
+
+	return records, gap
+}
+
+// Resume : returns, oldest first, the records following the given event
+// ID, and the ID they follow. IDs from another epoch, or without one, are
+// treated as unknown, so every retained record is returned with a gap.
+func (b *RingBuffer) Resume(eventID string) (records []Record, id uint64, gap bool, err error) {
+	epoch, seq := "", eventID
+	if i := strings.LastIndex(eventID, "-"); i >= 0 {
+		epoch, seq = eventID[:i], eventID[i+1:]
+	}
+
+	if id, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return nil, 0, false, errInvalidEventID
+	}
+
+	if epoch != b.epoch {
+		records, _ = b.After(0)
+		return records, 0, true, nil
+	}
+
+	records, gap = b.After(id)
+	return records, id, gap, nil
+}
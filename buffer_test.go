@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRingBuffer(t *testing.T) {
+	Convey("Given a ring buffer holding more records than its size", t, func() {
+		b := NewRingBuffer(3, 0)
+		for _, s := range []string{"instance.create", "network.create", "instance.create.error", "instance.delete"} {
+			b.Add(LogMessage{Subject: s, Level: "debug"})
+		}
+
+		Convey("only the last records should be kept, oldest first", func() {
+			records := b.Query(&Filter{})
+			So(len(records), ShouldEqual, 3)
+			So(records[0].Subject, ShouldEqual, "network.create")
+			So(records[2].Subject, ShouldEqual, "instance.delete")
+			So(records[2].ID, ShouldEqual, 4)
+		})
+
+		Convey("records should be filtered by subject pattern", func() {
+			records := b.Query(&Filter{Subject: "instance.>"})
+			So(len(records), ShouldEqual, 2)
+			records = b.Query(&Filter{Subject: "*.create"})
+			So(len(records), ShouldEqual, 1)
+		})
+
+		Convey("the limit should keep the most recent matches", func() {
+			records := b.Query(&Filter{Limit: 1})
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Subject, ShouldEqual, "instance.delete")
+		})
+	})
+
+	Convey("Given a ring buffer with a memory cap", t, func() {
+		b := NewRingBuffer(100, 3*recordOverhead+300)
+		for i := 0; i < 10; i++ {
+			b.Add(LogMessage{Subject: "x", Body: strings.Repeat("a", 100)})
+		}
+
+		Convey("records should be evicted to stay under the cap", func() {
+			So(len(b.Query(&Filter{})), ShouldBeLessThan, 4)
+		})
+	})
+
+	Convey("Given a client resuming from an event ID", t, func() {
+		b := NewRingBuffer(3, 0)
+		for i := 0; i < 5; i++ {
+			b.Add(LogMessage{Subject: "x"})
+		}
+
+		Convey("the events after it should be returned when retained", func() {
+			records, gap := b.After(3)
+			So(gap, ShouldBeFalse)
+			So(len(records), ShouldEqual, 2)
+			So(records[0].ID, ShouldEqual, 4)
+		})
+
+		Convey("a gap should be reported when some were evicted", func() {
+			records, gap := b.After(1)
+			So(gap, ShouldBeTrue)
+			So(len(records), ShouldEqual, 3)
+		})
+
+		Convey("nothing should be returned when it is up to date", func() {
+			records, gap := b.After(5)
+			So(gap, ShouldBeFalse)
+			So(len(records), ShouldEqual, 0)
+		})
+
+		Convey("a gap should be reported for unknown IDs", func() {
+			_, gap := b.After(42)
+			So(gap, ShouldBeTrue)
+		})
+
+		Convey("event IDs from the current epoch should resume after their record", func() {
+			records, id, gap, err := b.Resume(b.epoch + "-3")
+			So(err, ShouldBeNil)
+			So(gap, ShouldBeFalse)
+			So(id, ShouldEqual, 3)
+			So(len(records), ShouldEqual, 2)
+			So(records[0].EventID, ShouldEqual, b.epoch+"-4")
+		})
+
+		Convey("event IDs from a previous epoch should be reported as a gap", func() {
+			records, id, gap, err := b.Resume("previous-3")
+			So(err, ShouldBeNil)
+			So(gap, ShouldBeTrue)
+			So(id, ShouldEqual, 0)
+			So(len(records), ShouldEqual, 3)
+		})
+
+		Convey("event IDs without an epoch should be reported as a gap", func() {
+			_, _, gap, err := b.Resume("3")
+			So(err, ShouldBeNil)
+			So(gap, ShouldBeTrue)
+		})
+
+		Convey("invalid event IDs should be rejected", func() {
+			_, _, _, err := b.Resume(b.epoch + "-x")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	ads "github.com/ernestio/logger/adapters"
+)
+
+// commands : offline tools that can be run instead of the service, as
+// in `logger <command> [args]`
+var commands = map[string]func(args []string) int{
+	"verify":  verifyCommand,
+	"decrypt": decryptCommand,
+}
+
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command '"+args[0]+"'")
+		return 2
+	}
+	return cmd(args[1:])
+}
+
+// verifyCommand : checks a hash chained log file for gaps, reordering and
+// modifications. Checkpoint signatures are verified when the key is set,
+// and then required at least every -checkpoint-every lines.
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	every := flags.Int("checkpoint-every", 0, "checkpoint_every the log was written with")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: logger verify [-checkpoint-every n] <file>")
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var key []byte
+	if k := os.Getenv(ads.ChainKeyEnv); k != "" {
+		key = []byte(k)
+	} else {
+		fmt.Fprintln(os.Stderr, ads.ChainKeyEnv+" is not set, checkpoint signatures will not be verified")
+	}
+
+	report, err := ads.VerifyChain(f, key, *every)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+
+	if report.First > 1 {
+		fmt.Printf("starting at sequence %d\n", report.First)
+	}
+	fmt.Printf("%d lines, %d checkpoints, %d lines after the last checkpoint\n", report.Lines, report.Checkpoints, report.Unsigned)
+
+	if !report.Valid() {
+		fmt.Println("FAILED")
+		return 1
+	}
+
+	fmt.Println("OK")
+	return 0
+}
+
+// decryptCommand : streams the plain text of an encrypted log file to
+// stdout, using the same key settings as the basic logger
+func decryptCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: logger decrypt <file>")
+		return 2
+	}
+
+	key, err := ads.LoadEncryptionKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := ads.Decrypt(f, os.Stdout, key); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,295 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+// controlTokenField : body field carrying the token of a control request
+const controlTokenField = "_token"
+
+// controlTokenTTL : longest lifetime accepted for a control token, each
+// being usable only once
+const controlTokenTTL = 5 * time.Minute
+
+// controlTokenValue : the token field of a control request body, wherever
+// it appears, even on bodies that aren't valid JSON
+var controlTokenValue = regexp.MustCompile(`"` + controlTokenField + `"\s*:\s*"(?:[^"\\]|\\.)*"`)
+
+// Control targets other than adapter types
+const (
+	targetTokens = "tokens"
+	targetKeys   = "keys"
+	targetAny    = "*"
+)
+
+// ControlPolicy : decides who may send control requests, such as
+// logger.set or logger.del, and what they may change
+type ControlPolicy struct {
+	// Disabled : accepts control requests without token, as older
+	// versions did
+	Disabled bool
+	// ACL : targets, adapter types, tokens or keys, each identity may
+	// change. Without it only admins may send control requests.
+	ACL map[string][]string
+	// secret : verifies the tokens of services, signed with
+	// ERNEST_CONTROL_SECRET, on top of the ones accepted from users
+	secret *Verifier
+	// used : expiry of the control tokens already used, by jti
+	used map[string]time.Time
+	mu   sync.Mutex
+}
+
+// controlPolicyFromEnv : requires control requests to carry a token, a
+// user jwt or a HS256 jwt signed with ERNEST_CONTROL_SECRET, unless
+// ERNEST_CONTROL_AUTH is disabled. ERNEST_CONTROL_ACL is a JSON object
+// listing the targets of each identity.
+func controlPolicyFromEnv() (*ControlPolicy, error) {
+	p := ControlPolicy{Disabled: os.Getenv("ERNEST_CONTROL_AUTH") == "disabled"}
+
+	if s := os.Getenv("ERNEST_CONTROL_SECRET"); s != "" {
+		p.secret = &Verifier{
+			Algorithms: []string{jwt.SigningMethodHS256.Alg()},
+			secret:     []byte(s),
+		}
+	}
+
+	if acl := os.Getenv("ERNEST_CONTROL_ACL"); acl != "" {
+		if err := json.Unmarshal([]byte(acl), &p.ACL); err != nil {
+			return nil, errors.New("Invalid ERNEST_CONTROL_ACL: " + err.Error())
+		}
+	}
+
+	if p.Disabled {
+		diag.Warn("Control requests are accepted without token")
+	}
+
+	return &p, nil
+}
+
+// identify : returns who a control token belongs to and if it is an admin
+func (p *ControlPolicy) identify(token string) (string, bool, error) {
+	var claims jwt.MapClaims
+	var err error
+
+	if p.secret != nil {
+		claims, err = p.secret.Parse(token)
+	}
+	if claims == nil && verifier != nil {
+		claims, err = verifier.Parse(token)
+	}
+	if claims == nil {
+		if err == nil {
+			err = errUnauthorized
+		}
+		return "", false, err
+	}
+
+	name, _ := claims["username"].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	admin, _ := claims["admin"].(bool)
+
+	if name == "" {
+		return "", false, errUnauthorized
+	}
+
+	if err := p.spend(claims); err != nil {
+		return "", false, err
+	}
+
+	return name, admin, nil
+}
+
+// spend : accepts a control token only once, and only when it expires
+// within controlTokenTTL, so a token seen on the wire can't be replayed
+// later on
+func (p *ControlPolicy) spend(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	expires := time.Unix(int64(exp), 0)
+
+	if jti == "" || time.Until(expires) > controlTokenTTL {
+		return errUnauthorized
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range p.used {
+		if now.After(t) {
+			delete(p.used, id)
+		}
+	}
+
+	if _, ok := p.used[jti]; ok {
+		return errUnauthorized
+	}
+	if p.used == nil {
+		p.used = make(map[string]time.Time)
+	}
+	p.used[jti] = expires
+
+	return nil
+}
+
+// redact : hides the token of a control request from a message, before
+// it is obfuscated, kept, broadcast or sent to the adapters
+func redact(body string) string {
+	if !strings.Contains(body, controlTokenField) {
+		return body
+	}
+	return controlTokenValue.ReplaceAllString(body, `"`+controlTokenField+`":"`+obfuscation+`"`)
+}
+
+// permit : checks an identity may change the given target
+func (p *ControlPolicy) permit(name string, admin bool, target string) bool {
+	if p.ACL == nil {
+		return admin
+	}
+
+	allowed := p.ACL[name]
+	return matchAny(allowed, targetAny, equals) || matchAny(allowed, target, equals)
+}
+
+// control : checks a control request is allowed to change the target
+// found on it, returning the request without its token. Every decision
+// is audited.
+func control(m *nats.Msg, target func([]byte) string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var token string
+	if raw, ok := fields[controlTokenField]; ok {
+		_ = json.Unmarshal(raw, &token)
+		delete(fields, controlTokenField)
+	}
+
+	data := m.Data
+	if fields != nil {
+		data, _ = json.Marshal(fields)
+	}
+
+	p := currentControlPolicy()
+	if p.Disabled {
+		return data, nil
+	}
+
+	t := target(data)
+	name, admin, err := p.identify(token)
+	if err == nil && !p.permit(name, admin, t) {
+		err = errors.New("Not allowed to change " + t)
+	}
+
+	auditControl(name, "nats", m.Subject, t, err)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+
+	return data, nil
+}
+
+// errAPITokenChange : API tokens are meant to follow the logs, never to
+// change the logger's configuration, whatever their scope
+var errAPITokenChange = errors.New("API tokens can't change the logger's configuration")
+
+// errReplayableChange : changes over http need a control token too, so
+// a bearer token left in a proxy log or a shell history can't be replayed
+var errReplayableChange = errors.New("Changes need a token with a jti claim, expiring within 5 minutes, used once")
+
+// spendChange : applies the control token rules to the jwt of a change
+// made over http
+func spendChange(p *ControlPolicy, token string) error {
+	if verifier == nil {
+		return errUnauthorized
+	}
+
+	claims, err := verifier.Parse(token)
+	if err != nil {
+		return err
+	}
+
+	return p.spend(claims)
+}
+
+// permitChange : checks an admin may change the given target over http
+// with the given token, auditing the decision
+func permitChange(token, username, action, target string) error {
+	p := currentControlPolicy()
+
+	var err error
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		err = errAPITokenChange
+	} else if err = spendChange(p, token); err != nil {
+		err = errReplayableChange
+	} else if !p.permit(username, true, target) {
+		err = errors.New("Not allowed to change " + target)
+	}
+
+	auditControl(username, "http", action, target, err)
+
+	return err
+}
+
+// defaultControlPolicy : the policy used until one is set up, kept so
+// the tokens it spends are remembered
+var defaultControlPolicy = &ControlPolicy{}
+
+// currentControlPolicy : the policy in use, only admins being allowed
+// until one is set up
+func currentControlPolicy() *ControlPolicy {
+	if controlPolicy == nil {
+		return defaultControlPolicy
+	}
+	return controlPolicy
+}
+
+// auditControl : records a control request, accepted or refused
+func auditControl(name, transport, action, target string, err error) {
+	a := AuditRecord{
+		Event:     AuditControl,
+		Username:  name,
+		Transport: transport,
+		Action:    action + " " + target,
+	}
+	if err != nil {
+		diag.Warn("Refused " + a.Action + " from '" + name + "': " + err.Error())
+		a.Event = AuditRejected
+		a.Reason = err.Error()
+	}
+	audit.Record(a)
+}
+
+// adapterType : control target of logger.set and logger.del requests
+func adapterType(data []byte) string {
+	var adapter GenericAdapter
+	_ = json.Unmarshal(data, &adapter)
+	return adapter.Type
+}
+
+func tokensTarget([]byte) string {
+	return targetTokens
+}
+
+func keysTarget([]byte) string {
+	return targetKeys
+}
@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestControl(t *testing.T) {
+	exp := float64(time.Now().Add(time.Minute).Unix())
+	secret := &Verifier{Algorithms: []string{"HS256"}, secret: []byte("control")}
+
+	// every control token needs its own jti, as it can only be used once
+	var issued int
+	sign := func(key string, claims jwt.MapClaims) string {
+		issued++
+		claims["jti"] = strconv.Itoa(issued)
+		return signToken(jwt.SigningMethodHS256, "", []byte(key), claims)
+	}
+
+	request := func(token, body string) *nats.Msg {
+		fields := make(map[string]interface{})
+		_ = json.Unmarshal([]byte(body), &fields)
+		if token != "" {
+			fields[controlTokenField] = token
+		}
+		data, _ := json.Marshal(fields)
+		return &nats.Msg{Subject: "logger.set", Data: data}
+	}
+
+	Convey("Given a control policy with an allowlist", t, func() {
+		controlPolicy = &ControlPolicy{
+			ACL:    map[string][]string{"api-gateway": {"logstash"}, "ops": {"*"}},
+			secret: secret,
+		}
+		defer func() { controlPolicy = nil }()
+
+		gateway := func() string { return sign("control", jwt.MapClaims{"exp": exp, "sub": "api-gateway"}) }
+		ops := func() string { return sign("control", jwt.MapClaims{"exp": exp, "sub": "ops"}) }
+
+		Convey("an identity should change the adapter types it is allowed to", func() {
+			data, err := control(request(gateway(), `{"type":"logstash","port":2234}`), adapterType)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, controlTokenField)
+			So(adapterType(data), ShouldEqual, "logstash")
+
+			_, err = control(request(ops(), `{"type":"rollbar"}`), adapterType)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("other changes should be refused", func() {
+			_, err := control(request(gateway(), `{"type":"rollbar"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+			_, err = control(request(gateway(), `{}`), tokensTarget)
+			So(err, ShouldEqual, errUnauthorized)
+		})
+
+		Convey("requests without a valid token should be refused", func() {
+			_, err := control(request("", `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+
+			forged := sign("other", jwt.MapClaims{"exp": exp, "sub": "ops"})
+			_, err = control(request(forged, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+		})
+
+		Convey("a token should only be used once", func() {
+			token := ops()
+			_, err := control(request(token, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldBeNil)
+			_, err = control(request(token, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+		})
+
+		Convey("tokens without jti or living too long should be refused", func() {
+			token := signToken(jwt.SigningMethodHS256, "", []byte("control"), jwt.MapClaims{"exp": exp, "sub": "ops"})
+			_, err := control(request(token, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+
+			later := float64(time.Now().Add(time.Hour).Unix())
+			_, err = control(request(sign("control", jwt.MapClaims{"exp": later, "sub": "ops"}), `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+		})
+	})
+
+	Convey("Given a control policy without allowlist", t, func() {
+		controlPolicy = &ControlPolicy{secret: secret}
+		defer func() { controlPolicy = nil }()
+
+		Convey("only admins should send control requests", func() {
+			admin := sign("control", jwt.MapClaims{"exp": exp, "sub": "bot", "admin": true})
+			user := sign("control", jwt.MapClaims{"exp": exp, "sub": "bot"})
+
+			_, err := control(request(admin, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldBeNil)
+			_, err = control(request(user, `{"type":"logstash"}`), adapterType)
+			So(err, ShouldEqual, errUnauthorized)
+		})
+	})
+
+	Convey("Given control authorization is disabled", t, func() {
+		controlPolicy = &ControlPolicy{Disabled: true}
+		defer func() { controlPolicy = nil }()
+
+		Convey("requests without token should be accepted", func() {
+			_, err := control(request("", `{"type":"logstash"}`), adapterType)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a control request seen by the logger", t, func() {
+		token := sign("control", jwt.MapClaims{"exp": exp, "sub": "ops"})
+		body := string(request(token, `{"type":"logstash","port":2234}`).Data)
+
+		Convey("its token should be hidden", func() {
+			redacted := redact(body)
+			So(strings.Contains(redacted, token), ShouldBeFalse)
+			So(redacted, ShouldContainSubstring, `"port":2234`)
+			So(redact(`{"_token" : "`+token+`", "type":`), ShouldNotContainSubstring, token)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package diag
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level : severity of a diagnostic message
+type Level int
+
+const (
+	// DEBUG : verbose messages, only useful while troubleshooting
+	DEBUG Level = iota
+	// INFO : normal lifecycle messages
+	INFO
+	// WARN : recoverable problems
+	WARN
+	// ERROR : failures the service could not recover from
+	ERROR
+)
+
+var names = map[Level]string{
+	DEBUG: "debug",
+	INFO:  "info",
+	WARN:  "warn",
+	ERROR: "error",
+}
+
+func (l Level) String() string {
+	return names[l]
+}
+
+// ParseLevel : converts a level name into a Level, defaulting to INFO
+func ParseLevel(s string) Level {
+	for l, n := range names {
+		if strings.ToLower(s) == n {
+			return l
+		}
+	}
+	return INFO
+}
+
+var mu sync.RWMutex
+var level = INFO
+var out io.Writer = os.Stderr
+var std = log.New(out, "", log.LstdFlags)
+
+// Setup : configures the diagnostic logger from the environment.
+// ERNEST_DIAG_LEVEL sets the minimum level and ERNEST_DIAG_FILE an
+// optional file to write to instead of stderr.
+func Setup() error {
+	SetLevel(ParseLevel(os.Getenv("ERNEST_DIAG_LEVEL")))
+
+	path := os.Getenv("ERNEST_DIAG_FILE")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	SetOutput(f)
+
+	return nil
+}
+
+// SetLevel : sets the minimum level that will be written
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetOutput : sets the destination of diagnostic messages
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+	std.SetOutput(w)
+}
+
+// Output : returns the current diagnostic destination
+func Output() io.Writer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return out
+}
+
+func write(l Level, v ...interface{}) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if l < level {
+		return
+	}
+	std.Println(append([]interface{}{"[" + l.String() + "]"}, v...)...)
+}
+
+// Debug : writes a debug message
+func Debug(v ...interface{}) {
+	write(DEBUG, v...)
+}
+
+// Info : writes an info message
+func Info(v ...interface{}) {
+	write(INFO, v...)
+}
+
+// Warn : writes a warning message
+func Warn(v ...interface{}) {
+	write(WARN, v...)
+}
+
+// Error : writes an error message
+func Error(v ...interface{}) {
+	write(ERROR, v...)
+}
+
+// Fatal : writes an error message and exits
+func Fatal(v ...interface{}) {
+	write(ERROR, v...)
+	os.Exit(1)
+}
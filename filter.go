@@ -0,0 +1,66 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"time"
+
+	ads "github.com/ernestio/logger/adapters"
+	"github.com/ernestio/logger/store"
+)
+
+// Filter : criteria used to select log records. Lists match when any of
+// their values does.
+type Filter struct {
+	Subject  string    `json:"subject,omitempty"`
+	Subjects []string  `json:"subjects,omitempty"`
+	Level    string    `json:"level,omitempty"`
+	Levels   []string  `json:"levels,omitempty"`
+	Services []string  `json:"services,omitempty"`
+	Since    time.Time `json:"since,omitempty"`
+	Limit    int       `json:"limit,omitempty"`
+}
+
+// Match : checks if the given message satisfies the filter
+func (f *Filter) Match(m *LogMessage) bool {
+	if f.Subject != "" && !store.MatchSubject(f.Subject, m.Subject) {
+		return false
+	}
+	if f.Level != "" && f.Level != m.Level {
+		return false
+	}
+	if len(f.Subjects) > 0 && !matchAny(f.Subjects, m.Subject, store.MatchSubject) {
+		return false
+	}
+	if len(f.Levels) > 0 && !matchAny(f.Levels, m.Level, equals) {
+		return false
+	}
+	if len(f.Services) > 0 && !f.matchService(m) {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) matchService(m *LogMessage) bool {
+	for _, id := range append(ads.ServiceIDs(m.Body), ads.ServiceIDs(m.Message)...) {
+		if matchAny(f.Services, id, equals) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string, match func(string, string) bool) bool {
+	for _, p := range patterns {
+		if match(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equals(a, b string) bool {
+	return a == b
+}
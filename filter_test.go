@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func matchData(f *Filter, data []byte) bool {
+	var m LogMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return false
+	}
+	return f.Match(&m)
+}
+
+func TestFilter(t *testing.T) {
+	Convey("Given a filter on subjects, levels and services", t, func() {
+		f := Filter{
+			Subjects: []string{"instance.>", "network.create"},
+			Levels:   []string{"debug", "error"},
+			Services: []string{"svc-1"},
+		}
+
+		Convey("a message matching all of them should pass", func() {
+			So(matchData(&f, []byte(`{"subject":"instance.create.aws","level":"debug","body":"{\"service\":\"svc-1\"}"}`)), ShouldBeTrue)
+		})
+
+		Convey("a message on another subject should not pass", func() {
+			So(matchData(&f, []byte(`{"subject":"firewall.create","level":"debug","body":"{\"service\":\"svc-1\"}"}`)), ShouldBeFalse)
+		})
+
+		Convey("a message of another level should not pass", func() {
+			So(matchData(&f, []byte(`{"subject":"network.create","level":"info","body":"{\"service\":\"svc-1\"}"}`)), ShouldBeFalse)
+		})
+
+		Convey("a message of another service should not pass", func() {
+			So(matchData(&f, []byte(`{"subject":"network.create","level":"debug","body":"{\"service\":\"svc-2\"}"}`)), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given an empty filter", t, func() {
+		f := Filter{}
+		So(matchData(&f, []byte(`{"subject":"anything","level":"debug"}`)), ShouldBeTrue)
+	})
+
+	Convey("Given live events published on the bus", t, func() {
+		patternsToObfuscate = append(patternsToObfuscate, testPassword)
+
+		previousBuffer, previousEvents, previousViewers := buffer, events, viewers
+		defer func() { buffer, events, viewers = previousBuffer, previousEvents, previousViewers }()
+		buffer, events, viewers = NewRingBuffer(10, 0), NewRingBuffer(10, 0), NewHub(0, 0)
+
+		natsHandler(&nats.Msg{Subject: "instance.create.aws.error", Data: []byte(`{"error":"quota exceeded"}`)})
+		natsHandler(&nats.Msg{Subject: "instance.create.aws.done", Data: []byte(`{}`)})
+
+		Convey("error events should be matched by an error level filter", func() {
+			records := events.Query(&Filter{Levels: []string{"error"}})
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Subject, ShouldEqual, "instance.create.aws.error")
+		})
+
+		Convey("other events should be matched as info", func() {
+			records := events.Query(&Filter{Levels: []string{"info"}})
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Subject, ShouldEqual, "instance.create.aws.done")
+		})
+	})
+}
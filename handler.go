@@ -6,74 +6,256 @@ package main
 
 import (
 	//
+	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/r3labs/broadcast"
+)
+
+const (
+	// writeWait : time allowed to write a message to a client
+	writeWait = 10 * time.Second
+	// authWait : time allowed for a client to send its credentials
+	authWait = 10 * time.Second
+	// pongWait : time allowed without hearing from a client
+	pongWait = 60 * time.Second
+	// pingPeriod : keepalive interval, shorter than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// maxMessageSize : largest message accepted from a client
+	maxMessageSize = 64 * 1024
 )
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		return true
+		p := origins
+		if p == nil {
+			p = &OriginPolicy{}
+		}
+		return p.Check(r)
 	},
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
+	if !admit(w, r, "websocket") {
+		return
+	}
+
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		upgradefail(w)
 		return
 	}
 
-	var authorized bool
-	var ch chan *broadcast.Event
-	var sub *broadcast.Subscriber
+	var v *Viewer
+	var session *Session
+	out := &wsWriter{conn: c}
+	done := make(chan struct{})
 
 	defer func() {
+		close(done)
 		_ = c.Close()
 
-		if ch != nil && sub != nil {
-			sub.Disconnect(ch)
+		if v != nil {
+			viewers.Leave(v)
+			auditDisconnected(v, session, out.sent)
 		}
 	}()
 
+	c.SetReadLimit(maxMessageSize)
+	_ = c.SetReadDeadline(time.Now().Add(authWait))
+
+	session, err = authenticate(w, c)
+	if err != nil {
+		limits.failed(host(r.RemoteAddr))
+		auditDenied(r, "websocket", err.Error(), session)
+		return
+	}
+
+	if reason := limits.user(session.Username); reason != "" {
+		refuse(r, "websocket", reason, session)
+		closeTryLater(c, "too many requests")
+		return
+	}
+
+	v, err = register(session, r, "websocket")
+	if err != nil {
+		refuse(r, "websocket", capReason(err), session)
+		closeTryLater(c, "too many connections")
+		return
+	}
+	auditConnected(v, session)
+
+	controls := make(chan *Control)
+	go readControls(c, controls, done)
+
+	_ = stream(out, session, v, controls, nil)
+}
+
+// stream : sends a session the events it missed when resuming, or the
+// requested backlog, and then the live ones matching its filter, until
+// the client goes away or is evicted for falling behind
+func stream(out streamWriter, s *Session, v *Viewer, controls chan *Control, gone <-chan struct{}) error {
+	var lastID uint64
+	var err error
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+
+	switch {
+	case s.EventID != nil:
+		lastID, err = replay(out, s)
+	case s.Backlog > 0:
+		lastID, err = backlog(out, s)
+	}
+	if err != nil {
+		return err
+	}
+
 	for {
-		if !authorized {
-			areq, err := authenticate(w, c)
-			if err != nil {
-				return
+		select {
+		case data, ok := <-v.events:
+			if !ok {
+				if v.reason != "" {
+					return out.evict(v.reason)
+				}
+				return nil
 			}
 
-			sub, ch, err = register(w, areq)
-			if err != nil {
-				return
+			// events already sent while replaying are skipped
+			var r Record
+			if err := json.Unmarshal(data, &r); err != nil || r.ID <= lastID {
+				continue
+			}
+			if !s.Match(&r.LogMessage) {
+				continue
 			}
 
-			authorized = true
-		} else {
-			msg, ok := <-ch
+			if err := out.event(r.EventID, data); err != nil {
+				return err
+			}
+		case ctl, ok := <-controls:
 			if !ok {
-				return
+				return nil
+			}
+
+			status := []byte(`{"status": "ok"}`)
+			if ctl.Filter != nil {
+				s.Filter = *ctl.Filter
+			} else {
+				status = []byte(`{"status": "bad request"}`)
 			}
 
-			err := c.WriteMessage(websocket.TextMessage, msg.Data)
-			if err != nil {
-				_ = internalerror(w)
-				return
+			if err := out.notice("status", status); err != nil {
+				return err
+			}
+		case <-ping.C:
+			if err := out.ping(); err != nil {
+				return err
 			}
+		case <-gone:
+			return nil
 		}
 	}
 }
 
-func register(w http.ResponseWriter, s *Session) (*broadcast.Subscriber, chan *broadcast.Event, error) {
-	sub := bc.GetSubscriber(s.Username)
-	if sub == nil {
-		sub = broadcast.NewSubscriber(s.Username)
-		bc.Register("logs", sub)
+// streamWriter : sends events and notices to a live log client
+type streamWriter interface {
+	event(id string, data []byte) error
+	notice(kind string, data []byte) error
+	ping() error
+	evict(reason string) error
+}
+
+// wsWriter : writes to a websocket client, giving up on clients that
+// don't accept data within writeWait
+type wsWriter struct {
+	conn *websocket.Conn
+	sent int64
+}
+
+func (w *wsWriter) event(id string, data []byte) error {
+	return w.write(websocket.TextMessage, data)
+}
+
+func (w *wsWriter) notice(kind string, data []byte) error {
+	return w.write(websocket.TextMessage, data)
+}
+
+func (w *wsWriter) ping() error {
+	return w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+func (w *wsWriter) evict(reason string) error {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	return w.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
+func (w *wsWriter) write(mt int, data []byte) error {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	if err := w.conn.WriteMessage(mt, data); err != nil {
+		return err
+	}
+	w.sent += int64(len(data))
+
+	return nil
+}
+
+// Control : message sent by an authenticated client to change its session
+type Control struct {
+	Filter *Filter `json:"filter"`
+}
+
+// readControls : reads the messages sent by the client, handing them to
+// the connection handler, which is the only one allowed to write back.
+// The channel is closed once the client goes away.
+func readControls(c *websocket.Conn, controls chan *Control, done chan struct{}) {
+	defer close(controls)
+
+	// any message or pong keeps the connection alive
+	_ = c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = c.SetReadDeadline(time.Now().Add(pongWait))
+
+		var ctl Control
+		if err := json.Unmarshal(message, &ctl); err != nil {
+			ctl = Control{}
+		}
+
+		select {
+		case controls <- &ctl:
+		case <-done:
+			return
+		}
+	}
+}
+
+// register : creates the viewer for a new connection
+func register(s *Session, r *http.Request, transport string) (*Viewer, error) {
+	var tokenID string
+	if s.Identity != nil {
+		tokenID = s.Identity.TokenID
 	}
+	return viewers.Join(s.Username, tokenID, r.RemoteAddr, transport)
+}
 
-	return sub, sub.Connect(), nil
+// capReason : tells which connection limit refused a viewer
+func capReason(err error) string {
+	if err == ErrTooManyStreams {
+		return rejectStreamCap
+	}
+	return rejectUserCap
 }
 
 func upgradefail(w http.ResponseWriter) {
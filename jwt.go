@@ -0,0 +1,479 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+// jwksRefresh : minimum time between two fetches of a remote JWKS when
+// a token refers to an unknown key
+const jwksRefresh = time.Minute
+
+// defaultJWKSReload : how often the JWKS is checked for changes
+const defaultJWKSReload = 30 * time.Second
+
+var errUnauthorized = errors.New("Unauthorized")
+
+var keysListener = func(m *nats.Msg) {
+	if _, err := control(m, keysTarget); err != nil {
+		reply(m, nil, err)
+		return
+	}
+
+	err := verifier.Reload()
+	reply(m, map[string][]string{"keys": verifier.KeyIDs()}, err)
+}
+
+// Verifier : checks the signature and standard claims of the jwts sent
+// by clients
+type Verifier struct {
+	Algorithms []string
+	Audience   string
+	Issuer     string
+	secret     []byte
+	jwks       string
+	keys       map[string]interface{}
+	fetched    time.Time
+	mu         sync.RWMutex
+}
+
+// newVerifierFromEnv : accepts the algorithms in JWT_ALGORITHMS (HS256 by
+// default), signed with JWT_SECRET or a key from the JWKS file or URL in
+// JWT_JWKS, and requires the JWT_AUDIENCE and JWT_ISSUER when set. The
+// JWKS is reloaded every JWT_JWKS_RELOAD, 0 disabling it.
+func newVerifierFromEnv() (*Verifier, error) {
+	v := Verifier{
+		Algorithms: splitList(os.Getenv("JWT_ALGORITHMS")),
+		Audience:   os.Getenv("JWT_AUDIENCE"),
+		Issuer:     os.Getenv("JWT_ISSUER"),
+		secret:     []byte(os.Getenv("JWT_SECRET")),
+		jwks:       os.Getenv("JWT_JWKS"),
+		keys:       make(map[string]interface{}),
+	}
+
+	if len(v.Algorithms) == 0 {
+		v.Algorithms = []string{jwt.SigningMethodHS256.Alg()}
+	}
+	for _, alg := range v.Algorithms {
+		if m := jwt.GetSigningMethod(alg); m == nil || m == jwt.SigningMethodNone {
+			return nil, errors.New("Unsupported jwt algorithm '" + alg + "'")
+		}
+	}
+
+	if v.jwks == "" {
+		return &v, nil
+	}
+
+	if err := v.load(); err != nil {
+		return nil, err
+	}
+
+	reload := defaultJWKSReload
+	if s := os.Getenv("JWT_JWKS_RELOAD"); s != "" {
+		var err error
+		if reload, err = time.ParseDuration(s); err != nil {
+			return nil, errors.New("Invalid JWT_JWKS_RELOAD: " + err.Error())
+		}
+	}
+	if reload > 0 {
+		go v.watch(reload)
+	}
+
+	return &v, nil
+}
+
+// Parse : validates the given token, returning its claims
+func (v *Verifier) Parse(t string) (jwt.MapClaims, error) {
+	p := jwt.Parser{ValidMethods: v.Algorithms}
+
+	// the candidate keys are resolved once, on the first attempt, so a
+	// reload in between can't change the list being walked
+	var keys []interface{}
+	var resolved bool
+
+	// each candidate key is tried in turn until one verifies the token
+	for i := 0; ; i++ {
+		claims := jwt.MapClaims{}
+
+		token, err := p.ParseWithClaims(t, claims, func(t *jwt.Token) (interface{}, error) {
+			if !resolved {
+				var err error
+				if keys, err = v.candidates(t); err != nil {
+					return nil, err
+				}
+				resolved = true
+			}
+			if i >= len(keys) {
+				return nil, errUnauthorized
+			}
+			return keys[i], nil
+		})
+		if err == nil && token.Valid {
+			if err := v.checkClaims(claims); err != nil {
+				return nil, err
+			}
+			return claims, nil
+		}
+
+		if !resolved || i+1 >= len(keys) {
+			return nil, errUnauthorized
+		}
+	}
+}
+
+// checkClaims : makes sure the token has not expired and, when
+// configured, was issued by and for the expected parties. Claims of an
+// unexpected type are refused.
+func (v *Verifier) checkClaims(claims jwt.MapClaims) error {
+	now := float64(time.Now().Unix())
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now >= exp {
+		return errUnauthorized
+	}
+
+	if nbf, ok := claims["nbf"]; ok {
+		if n, ok := nbf.(float64); !ok || now < n {
+			return errUnauthorized
+		}
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return errUnauthorized
+		}
+	}
+
+	if v.Audience != "" && !matchAny(claimList(claims["aud"]), v.Audience, equals) {
+		return errUnauthorized
+	}
+
+	return nil
+}
+
+// candidates : returns the keys a token may be signed with, the one
+// named by its kid header or, without it, JWT_SECRET and every key of
+// the right type, so tokens keep working while keys are rotated
+func (v *Verifier) candidates(t *jwt.Token) ([]interface{}, error) {
+	if kid, _ := t.Header["kid"].(string); kid != "" {
+		key := v.lookup(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown jwt key id=%s", kid)
+		}
+		if !compatible(t.Method, key) {
+			return nil, fmt.Errorf("jwt key id=%s can't be used with %s", kid, t.Method.Alg())
+		}
+		return []interface{}{key}, nil
+	}
+
+	var keys []interface{}
+	if compatible(t.Method, v.secret) {
+		keys = append(keys, v.secret)
+	}
+
+	v.mu.RLock()
+	for _, id := range v.ids() {
+		if compatible(t.Method, v.keys[id]) {
+			keys = append(keys, v.keys[id])
+		}
+	}
+	v.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no jwt key available for %s", t.Method.Alg())
+	}
+
+	return keys, nil
+}
+
+func (v *Verifier) lookup(kid string) interface{} {
+	if key := v.get(kid); key != nil {
+		return key
+	}
+
+	// keys published after the last fetch are picked up, without letting
+	// unknown ids trigger a request each
+	v.mu.RLock()
+	stale := isURL(v.jwks) && time.Since(v.fetched) > jwksRefresh
+	v.mu.RUnlock()
+
+	if stale {
+		if err := v.load(); err != nil {
+			diag.Error(err.Error())
+		}
+		return v.get(kid)
+	}
+
+	return nil
+}
+
+func (v *Verifier) get(kid string) interface{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.keys[kid]
+}
+
+// ids : returns the ids of the loaded keys, sorted. The caller must hold
+// the lock.
+func (v *Verifier) ids() []string {
+	ids := make([]string, 0, len(v.keys))
+	for id := range v.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// KeyIDs : returns the ids of the keys currently accepted
+func (v *Verifier) KeyIDs() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.ids()
+}
+
+// Reload : reads the JWKS again, keeping the current keys if it fails
+func (v *Verifier) Reload() error {
+	if v.jwks == "" {
+		return errors.New("No JWKS configured, set JWT_JWKS")
+	}
+	return v.load()
+}
+
+// watch : reloads the JWKS file whenever it changes, or a remote set on
+// every interval
+func (v *Verifier) watch(interval time.Duration) {
+	var modified time.Time
+	if info, err := os.Stat(v.jwks); err == nil {
+		modified = info.ModTime()
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		if !isURL(v.jwks) {
+			info, err := os.Stat(v.jwks)
+			if err != nil {
+				diag.Error(err.Error())
+				continue
+			}
+			if info.ModTime().Equal(modified) {
+				continue
+			}
+			modified = info.ModTime()
+		}
+
+		if err := v.load(); err != nil {
+			diag.Error(err.Error())
+			continue
+		}
+		diag.Info("JWT keys reloaded from '" + v.jwks + "'")
+	}
+}
+
+// load : reads the JWKS from its file or URL
+func (v *Verifier) load() error {
+	var data []byte
+	var err error
+
+	if isURL(v.jwks) {
+		data, err = fetch(v.jwks)
+	} else {
+		data, err = ioutil.ReadFile(v.jwks)
+	}
+	if err != nil {
+		return errors.New("Could not read JWKS from '" + v.jwks + "': " + err.Error())
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return errors.New("Invalid JWKS on '" + v.jwks + "': " + err.Error())
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetched = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// JWK : a single key of a JSON Web Key Set
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+// parseJWKS : returns the signature keys of a JSON Web Key Set by id.
+// RSA, EC and symmetric keys are supported.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var signing []JWK
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			signing = append(signing, k)
+		}
+	}
+
+	// keys are told apart by their kid, only a lone key may go without
+	keys := make(map[string]interface{})
+	for _, k := range signing {
+		if k.Kid == "" && len(signing) > 1 {
+			return nil, errors.New("every key needs a kid when the set has more than one")
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return nil, errors.New("key '" + k.Kid + "' is listed more than once")
+		}
+
+		key, err := k.key()
+		if err != nil {
+			return nil, errors.New("key '" + k.Kid + "': " + err.Error())
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k *JWK) key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return decodeSegment(k.K)
+	}
+
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// compatible : prevents a key from being used with an algorithm of a
+// different family, e.g. a public RSA key as an HMAC secret
+func compatible(m jwt.SigningMethod, key interface{}) bool {
+	switch m.(type) {
+	case *jwt.SigningMethodHMAC:
+		k, ok := key.([]byte)
+		return ok && len(k) > 0
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	}
+	return false
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// splitList : splits a comma separated setting
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,223 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func signToken(m jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t := jwt.NewWithClaims(m, claims)
+	if kid != "" {
+		t.Header["kid"] = kid
+	}
+	s, _ := t.SignedString(key)
+	return s
+}
+
+func TestVerifier(t *testing.T) {
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	Convey("Given a verifier using a shared secret", t, func() {
+		v := Verifier{Algorithms: []string{"HS256"}, secret: []byte("test")}
+
+		Convey("it should accept tokens signed with the secret", func() {
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp}))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("it should refuse tokens signed with another secret or algorithm", func() {
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("other"), jwt.MapClaims{"exp": exp}))
+			So(err, ShouldNotBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodHS512, "", []byte("test"), jwt.MapClaims{"exp": exp}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("it should refuse expired tokens, or tokens without expiry", func() {
+			past := float64(time.Now().Add(-time.Minute).Unix())
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": past}))
+			So(err, ShouldNotBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{}))
+			So(err, ShouldNotBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": "never"}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("it should refuse tokens not valid yet", func() {
+			nbf := float64(time.Now().Add(time.Minute).Unix())
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp, "nbf": nbf}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("with an audience and issuer it should require both", func() {
+			v.Audience = "logger"
+			v.Issuer = "ernest"
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp, "aud": []interface{}{"api", "logger"}, "iss": "ernest"}))
+			So(err, ShouldBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp, "aud": "api", "iss": "ernest"}))
+			So(err, ShouldNotBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodHS256, "", []byte("test"), jwt.MapClaims{"exp": exp, "aud": "logger"}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a verifier using a JWKS file", t, func() {
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		jwks, _ := json.Marshal(map[string][]JWK{"keys": {
+			{Kid: "rsa", Kty: "RSA", N: encodeBigInt(rsaKey.N), E: encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+			{Kid: "ec", Kty: "EC", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)},
+		}})
+
+		dir, _ := ioutil.TempDir("", "jwks")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, "jwks.json")
+		_ = ioutil.WriteFile(path, jwks, 0600)
+
+		v := Verifier{Algorithms: []string{"RS256", "ES256", "HS256"}, jwks: path}
+		So(v.load(), ShouldBeNil)
+
+		Convey("it should find the key of RS256 and ES256 tokens by kid", func() {
+			_, err := v.Parse(signToken(jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"exp": exp}))
+			So(err, ShouldBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodES256, "ec", ecKey, jwt.MapClaims{"exp": exp}))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("it should refuse unknown or mismatched keys", func() {
+			_, err := v.Parse(signToken(jwt.SigningMethodRS256, "other", rsaKey, jwt.MapClaims{"exp": exp}))
+			So(err, ShouldNotBeNil)
+			_, err = v.Parse(signToken(jwt.SigningMethodRS256, "ec", rsaKey, jwt.MapClaims{"exp": exp}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("it should not use a public key as an HMAC secret", func() {
+			_, err := v.Parse(signToken(jwt.SigningMethodHS256, "rsa", rsaKey.N.Bytes(), jwt.MapClaims{"exp": exp}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a verifier rotating between two secrets", t, func() {
+		dir, _ := ioutil.TempDir("", "jwks")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, "jwks.json")
+
+		write := func(keys ...JWK) {
+			data, _ := json.Marshal(map[string][]JWK{"keys": keys})
+			_ = ioutil.WriteFile(path, data, 0600)
+		}
+		oldKey := JWK{Kid: "2017-10", Kty: "oct", K: base64.RawURLEncoding.EncodeToString([]byte("old"))}
+		newKey := JWK{Kid: "2017-11", Kty: "oct", K: base64.RawURLEncoding.EncodeToString([]byte("new"))}
+		write(oldKey, newKey)
+
+		v := Verifier{Algorithms: []string{"HS256"}, jwks: path}
+		So(v.Reload(), ShouldBeNil)
+		So(v.KeyIDs(), ShouldResemble, []string{"2017-10", "2017-11"})
+
+		oldToken := signToken(jwt.SigningMethodHS256, "", []byte("old"), jwt.MapClaims{"exp": exp})
+		newToken := signToken(jwt.SigningMethodHS256, "2017-11", []byte("new"), jwt.MapClaims{"exp": exp})
+
+		Convey("it should accept tokens signed with either of them", func() {
+			_, err := v.Parse(oldToken)
+			So(err, ShouldBeNil)
+			_, err = v.Parse(newToken)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("once the old one is removed and the keys reloaded", func() {
+			write(newKey)
+			So(v.Reload(), ShouldBeNil)
+
+			Convey("only the tokens signed with the new one should be accepted", func() {
+				_, err := v.Parse(oldToken)
+				So(err, ShouldNotBeNil)
+				_, err = v.Parse(newToken)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("tokens should be parsed while the keys are being reloaded", func() {
+			token := signToken(jwt.SigningMethodHS256, "", []byte("new"), jwt.MapClaims{"exp": exp})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 200; i++ {
+					if i%2 == 0 {
+						write(newKey)
+					} else {
+						write(oldKey, newKey)
+					}
+					_ = v.Reload()
+				}
+			}()
+
+			failed := 0
+			for running := true; running; {
+				select {
+				case <-done:
+					running = false
+				default:
+				}
+				if _, err := v.Parse(token); err != nil {
+					failed++
+				}
+			}
+			So(failed, ShouldEqual, 0)
+		})
+
+		Convey("a broken key set should not replace the current one", func() {
+			_ = ioutil.WriteFile(path, []byte("{"), 0600)
+			So(v.Reload(), ShouldNotBeNil)
+			So(len(v.KeyIDs()), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a key set with keys without kid", t, func() {
+		oct := func(kid, secret string) JWK {
+			return JWK{Kid: kid, Kty: "oct", K: base64.RawURLEncoding.EncodeToString([]byte(secret))}
+		}
+		set := func(keys ...JWK) []byte {
+			data, _ := json.Marshal(map[string][]JWK{"keys": keys})
+			return data
+		}
+
+		Convey("a lone key should be accepted", func() {
+			keys, err := parseJWKS(set(oct("", "one")))
+			So(err, ShouldBeNil)
+			So(len(keys), ShouldEqual, 1)
+		})
+
+		Convey("several of them should be refused instead of overwriting each other", func() {
+			_, err := parseJWKS(set(oct("", "one"), oct("", "two")))
+			So(err, ShouldNotBeNil)
+			_, err = parseJWKS(set(oct("2017-11", "one"), oct("", "two")))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("keys sharing a kid should be refused", func() {
+			_, err := parseJWKS(set(oct("2017-11", "one"), oct("2017-11", "two")))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 
+	"github.com/ernestio/logger/diag"
+	"github.com/ernestio/logger/store"
 	"github.com/nats-io/go-nats"
 )
 
@@ -19,11 +20,37 @@ type LogMessage struct {
 var logListener = func(m *nats.Msg) {
 	var l LogMessage
 	if err := json.Unmarshal(m.Data, &l); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 		return
 	}
 
-	for _, adapter := range adapters {
+	r := l
+	r.Message = Obfuscate(l.Subject, l.Message)
+	r.Body = Obfuscate(l.Subject, l.Body)
+	keep(r)
+
+	for _, adapter := range activeAdapters() {
 		adapter.Log(l.Subject, l.Message, l.Level, l.User)
 	}
 }
+
+// keep : stores an obfuscated message on the ring buffer and, when
+// enabled, on the persistent store
+func keep(m LogMessage) {
+	buffer.Add(m)
+
+	if logstore == nil {
+		return
+	}
+
+	_, err := logstore.Append(store.Record{
+		Subject: m.Subject,
+		Message: m.Message,
+		Body:    m.Body,
+		Level:   m.Level,
+		User:    m.User,
+	})
+	if err != nil {
+		diag.Error(err.Error())
+	}
+}
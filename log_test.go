@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ernestio/logger/store"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogListener(t *testing.T) {
+	Convey("Given a log message with a secret on its body", t, func() {
+		needle := "b0dyn33dl3"
+		patternsToObfuscate = append(patternsToObfuscate, needle)
+
+		dir, _ := ioutil.TempDir("", "logger")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		s, err := store.Open(store.Config{Dir: dir, FullText: true})
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		previousBuffer, previousStore := buffer, logstore
+		buffer, logstore = NewRingBuffer(10, 0), s
+		defer func() { buffer, logstore = previousBuffer, previousStore }()
+
+		data, _ := json.Marshal(LogMessage{
+			Subject: "instance.create",
+			Message: "creating instance",
+			Body:    `{"name":"web-1","password":"` + needle + `"}`,
+			Level:   "info",
+			User:    "john",
+		})
+		logListener(&nats.Msg{Subject: "logger.log", Data: data})
+
+		Convey("it should be obfuscated on the tail buffer", func() {
+			records := buffer.Query(&Filter{})
+			So(records, ShouldHaveLength, 1)
+			So(strings.Contains(records[0].Body, needle), ShouldBeFalse)
+			So(strings.Contains(records[0].Body, "web-1"), ShouldBeTrue)
+		})
+
+		Convey("it should not be found on the store", func() {
+			ids, err := s.Match(needle, 0)
+			So(err, ShouldBeNil)
+			So(ids, ShouldBeEmpty)
+
+			ids, err = s.Match("web-1", 0)
+			So(err, ShouldBeNil)
+			So(ids, ShouldHaveLength, 1)
+		})
+	})
+}
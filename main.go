@@ -6,146 +6,149 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	ecc "github.com/ernestio/ernest-config-client"
 	ads "github.com/ernestio/logger/adapters"
+	"github.com/ernestio/logger/diag"
+	"github.com/ernestio/logger/store"
 	"github.com/nats-io/go-nats"
-	"github.com/r3labs/broadcast"
 )
 
-var silent bool
-var secret string
 var err error
 var nc *nats.Conn
-var bc *broadcast.Server
+var viewers *Hub
 var messages []string
 var adapters map[string]ads.Adapter
 var patternsToObfuscate []string
-
-func registerAdapter(a *ads.Adapter, m *nats.Msg, err error) {
-	if err != nil {
-		log.Println(err.Error())
-		if err := nc.Publish(m.Reply, []byte(`{"_error":"`+err.Error()+`"}`)); err != nil {
-			log.Println(err.Error())
-		}
-	} else {
-		persist(m)
-		if err = (*a).Manage(messages, Obfuscate); err != nil {
-			log.Println(err.Error())
-		}
-		adapters[(*a).Name()] = *a
-		body, _ := json.Marshal(adapters[(*a).Name()])
-		if err := nc.Publish(m.Reply, body); err != nil {
-			log.Println(err.Error())
-		}
-	}
+var buffer *RingBuffer
+var events *RingBuffer
+var logstore *store.Store
+var verifier *Verifier
+var apitokens *TokenStore
+var origins *OriginPolicy
+var audit *Audit
+var limits *Limits
+var controlPolicy *ControlPolicy
+
+// constructors : adapter types that can be set, by name
+var constructors = map[string]func(*nats.Conn, []byte) (ads.Adapter, error){
+	"basic":    ads.NewBasicAdapter,
+	"logstash": ads.NewLogstashAdapter,
+	"rollbar":  ads.NewRollbarAdapter,
 }
 
-var newBasicAdapterListener = func(m *nats.Msg) {
-	a, err := ads.NewBasicAdapter(nc, m.Data)
-	registerAdapter(&a, m, err)
-}
+var errInvalidAdapter = errors.New("Invalid logger type")
+var errBasicRequired = errors.New("Basic logger is not optional")
+var errAdapterNotFound = errors.New("Logger not found")
 
-var newLogstashAdapterListener = func(m *nats.Msg) {
-	a, err := ads.NewLogstashAdapter(nc, m.Data)
-	registerAdapter(&a, m, err)
-}
+// adaptersMu : guards the active adapters, managed over nats and http
+var adaptersMu sync.RWMutex
 
-var newRollbarAdapterListener = func(m *nats.Msg) {
-	a, err := ads.NewRollbarAdapter(nc, m.Data)
-	registerAdapter(&a, m, err)
-}
+// adapterConfigs : config of the adapters started, by type, used to
+// restore one when its replacement fails
+var adapterConfigs = make(map[string][]byte)
 
 // GenericAdapter : Minimal implementation of an adapter
 type GenericAdapter struct {
 	Type string `json:"type"`
 }
 
-var newAdapterListener = func(m *nats.Msg) {
+// setAdapter : starts the adapter described by the given config,
+// replacing the running one of the same type, and persists it
+func setAdapter(config []byte) (ads.Adapter, error) {
 	var adapter GenericAdapter
-	if err := json.Unmarshal(m.Data, &adapter); err != nil {
-		log.Println("Error processing logger creation")
-		log.Println(err.Error())
-		return
+	if err := json.Unmarshal(config, &adapter); err != nil {
+		return nil, err
 	}
 
-	switch adapter.Type {
-	case "basic":
-		silent = true
-		deleteAdapterListener(m)
-		silent = false
-		newBasicAdapterListener(m)
-	case "logstash":
-		silent = true
-		deleteAdapterListener(m)
-		silent = false
-		newLogstashAdapterListener(m)
-	case "rollbar":
-		silent = true
-		deleteAdapterListener(m)
-		silent = false
-		newRollbarAdapterListener(m)
+	create, ok := constructors[adapter.Type]
+	if !ok {
+		return nil, errInvalidAdapter
 	}
-}
 
-var deleteAdapterListener = func(m *nats.Msg) {
-	var adapter GenericAdapter
-	if err := json.Unmarshal(m.Data, &adapter); err != nil {
-		log.Println("Error processing logger deletion")
-		log.Println(err.Error())
-		if err := nc.Publish(m.Reply, []byte(`{"error":"`+err.Error()+`"}`)); err != nil {
-			log.Println(err.Error())
-			return
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+
+	// the running adapter is stopped first, as its replacement may need
+	// the files it holds, and started again if the new one can't be
+	running := adapters[adapter.Type] != nil
+	stopAdapter(adapter.Type)
+
+	a, err := create(nc, config)
+	if err != nil {
+		if running {
+			restoreAdapter(adapter.Type)
 		}
+		return nil, err
 	}
 
-	if adapters[adapter.Type] == nil {
+	persist(config)
+	startAdapter(a, config)
+
+	return a, nil
+}
+
+// restoreAdapter : starts again the last adapter of the given type. The
+// caller must hold adaptersMu.
+func restoreAdapter(name string) {
+	a, err := constructors[name](nc, adapterConfigs[name])
+	if err != nil {
+		diag.Error("Could not restore the " + name + " logger")
+		diag.Error(err.Error())
 		return
 	}
+	startAdapter(a, adapterConfigs[name])
+}
 
-	if adapter.Type == "basic" {
-		if silent == true {
-			adapters[adapter.Type].Stop()
-			adapters[adapter.Type] = nil
-		} else {
-			log.Println("Basic adapter is not optional")
-			if err := nc.Publish(m.Reply, []byte(`{"error":"Basic logger is not optional"}`)); err != nil {
-				log.Println(err.Error())
-			}
-		}
-		return
+// startAdapter : the caller must hold adaptersMu
+func startAdapter(a ads.Adapter, config []byte) {
+	if err := a.Manage(messages, Obfuscate); err != nil {
+		diag.Error(err.Error())
 	}
+	adapters[a.Name()] = a
+	adapterConfigs[a.Name()] = config
+}
 
-	if adapter.Type == "logstash" || adapter.Type == "rollbar" || adapter.Type == "sse" {
-		if _, ok := adapters[adapter.Type]; ok && adapters[adapter.Type] != nil {
-			adapters[adapter.Type].Stop()
-			adapters[adapter.Type] = nil
-			if silent == false {
-				body, _ := json.Marshal(adapters[adapter.Type])
-				if err := nc.Publish(m.Reply, body); err != nil {
-					log.Println(err.Error())
-				}
-			}
-			return
-		}
+// deleteAdapter : stops a running adapter. The basic one can only be
+// replaced.
+func deleteAdapter(name string) error {
+	if name == "basic" {
+		return errBasicRequired
 	}
-	if silent == false {
-		if err := nc.Publish(m.Reply, []byte(`{"error":"Invalid logger type"}`)); err != nil {
-			log.Println(err.Error())
-		}
+	if _, ok := constructors[name]; !ok {
+		return errInvalidAdapter
+	}
+
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+
+	if adapters[name] == nil {
+		return errAdapterNotFound
 	}
+	stopAdapter(name)
+
+	return nil
 }
 
-var findAdapterListener = func(m *nats.Msg) {
-	var body []byte
-	var err error
+// stopAdapter : the caller must hold adaptersMu
+func stopAdapter(name string) {
+	if a := adapters[name]; a != nil {
+		a.Stop()
+		adapters[name] = nil
+	}
+}
+
+// activeAdapters : returns the running adapters
+func activeAdapters() []ads.Adapter {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+
 	active := make([]ads.Adapter, 0)
 	for _, a := range adapters {
 		if a != nil {
@@ -153,21 +156,85 @@ var findAdapterListener = func(m *nats.Msg) {
 		}
 	}
 
-	if body, err = json.Marshal(active); err != nil {
-		if err := nc.Publish(m.Reply, []byte(`{"error":"Unexpected error ocurred"}`)); err != nil {
-			log.Println("An error occurred responding")
+	return active
+}
+
+// findAdapter : returns a running adapter by type
+func findAdapter(name string) (ads.Adapter, error) {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+
+	if adapters[name] == nil {
+		return nil, errAdapterNotFound
+	}
+
+	return adapters[name], nil
+}
+
+var newAdapterListener = func(m *nats.Msg) {
+	var a ads.Adapter
+
+	config, err := control(m, adapterType)
+	if err == nil {
+		a, err = setAdapter(config)
+	}
+	if err != nil {
+		diag.Error("Error processing logger creation")
+		diag.Error(err.Error())
+		if err := nc.Publish(m.Reply, []byte(`{"_error":"`+err.Error()+`"}`)); err != nil {
+			diag.Error(err.Error())
 		}
+		return
 	}
 
+	body, _ := json.Marshal(a)
 	if err := nc.Publish(m.Reply, body); err != nil {
-		log.Println("An error occurred responding")
+		diag.Error(err.Error())
+	}
+}
+
+var deleteAdapterListener = func(m *nats.Msg) {
+	var adapter GenericAdapter
+
+	data, err := control(m, adapterType)
+	if err == nil {
+		err = json.Unmarshal(data, &adapter)
+	}
+	if err != nil {
+		diag.Error("Error processing logger deletion")
+		diag.Error(err.Error())
+		if err := nc.Publish(m.Reply, []byte(`{"error":"`+err.Error()+`"}`)); err != nil {
+			diag.Error(err.Error())
+		}
+		return
+	}
+
+	body := []byte("null")
+	if err := deleteAdapter(adapter.Type); err != nil {
+		diag.Warn(err.Error())
+		body = []byte(`{"error":"` + err.Error() + `"}`)
+	}
+
+	if err := nc.Publish(m.Reply, body); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+var findAdapterListener = func(m *nats.Msg) {
+	body, err := json.Marshal(activeAdapters())
+	if err != nil {
+		body = []byte(`{"error":"Unexpected error ocurred"}`)
+	}
+
+	if err := nc.Publish(m.Reply, body); err != nil {
+		diag.Error("An error occurred responding")
 	}
 }
 
 var addPatterns = func(m *nats.Msg) {
 	var d Datacenter
 	if err := json.Unmarshal(m.Data, &d); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 		return
 	}
 	addDatacenterPatterns(d, &patternsToObfuscate)
@@ -177,45 +244,61 @@ var addPatterns = func(m *nats.Msg) {
 func DefaultAdapter() {
 	if err := load(); err != nil {
 		if path := os.Getenv("ERNEST_LOG_FILE"); path != "" {
-			m := nats.Msg{}
-			m.Data = []byte(`{"type":"basic","logfile":"` + path + `"}`)
-			newBasicAdapterListener(&m)
+			if _, err := setAdapter([]byte(`{"type":"basic","logfile":"` + path + `"}`)); err != nil {
+				diag.Error(err.Error())
+			}
 		}
 	}
 }
 
 func setupFilesystem() {
-	logfile := os.Getenv("ERNEST_LOG_FILE")
 	logcfg := os.Getenv("ERNEST_LOG_CONFIG")
 
-	logdir := filepath.Dir(logfile)
-
-	dirs := []string{logcfg, logdir}
-
-	for _, v := range dirs {
-		err := os.MkdirAll(v, 0755)
-		if err != nil && !os.IsExist(err) {
-			log.Fatal(err)
-		}
+	// the log file and its directory are left to the basic adapter, which
+	// creates them with the configured permissions and appends to any
+	// existing log rather than truncating it
+	err := os.MkdirAll(logcfg, 0755)
+	if err != nil && !os.IsExist(err) {
+		diag.Fatal(err)
 	}
+}
 
-	f, err := os.Create(logfile)
-	if err != nil {
-		log.Fatal(err)
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
 	}
-	err = f.Close()
-	if err != nil {
-		log.Fatal(err)
+
+	if err := diag.Setup(); err != nil {
+		diag.Error(err.Error())
 	}
-}
 
-func main() {
 	setupFilesystem()
 
+	if err := setupScopeRules(); err != nil {
+		diag.Fatal(err)
+	}
+	setupTokens()
+
 	messages = []string{"*", "*.*", "*.*.*", "*.*.*.*"}
 	adapters = make(map[string]ads.Adapter)
+	buffer = newRingBufferFromEnv("ERNEST_TAIL")
+	events = newRingBufferFromEnv("ERNEST_REPLAY")
+	viewers = newHubFromEnv()
+	limits = newLimitsFromEnv()
+	setupStore()
+	if logstore != nil {
+		defer logstore.Close()
+	}
+
+	if verifier, err = newVerifierFromEnv(); err != nil {
+		diag.Fatal(err)
+	}
+	if controlPolicy, err = controlPolicyFromEnv(); err != nil {
+		diag.Fatal(err)
+	}
 
 	nc = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
+	audit = newAuditFromEnv(nc)
 
 	for {
 		// wait for project store to become available
@@ -223,55 +306,108 @@ func main() {
 		if err == nil {
 			break
 		}
-		log.Println("could not get secrets")
+		diag.Warn("could not get secrets")
 		time.Sleep(time.Second * 3)
 	}
 
 	DefaultAdapter()
 
 	if _, err = nc.Subscribe("logger.del", deleteAdapterListener); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	}
 
 	if _, err = nc.Subscribe("logger.set", newAdapterListener); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	}
 
 	if _, err = nc.Subscribe("logger.find", findAdapterListener); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	}
 
 	if _, err = nc.Subscribe("datacenter.set", addPatterns); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	}
 
 	if _, err = nc.Subscribe("logger.log", logListener); err != nil {
-		log.Println(err.Error())
+		diag.Error(err.Error())
 	}
 
-	secret = os.Getenv("JWT_SECRET")
+	if _, err = nc.Subscribe("logger.tail", tailListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.search", searchListener); err != nil {
+		diag.Error(err.Error())
+	}
 
-	bc = broadcast.New()
-	defer bc.Close()
+	if _, err = nc.Subscribe("logger.match", matchListener); err != nil {
+		diag.Error(err.Error())
+	}
 
-	s := bc.CreateStream("logs")
-	s.AutoReplay = false
-	s.MaxInactivity = time.Hour * 8760
+	if _, err = nc.Subscribe("logger.context", contextListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.viewers", viewersListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.audits", auditsListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.metrics", metricsListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.keys.reload", keysListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.token.create", createTokenListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.token.list", listTokensListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	if _, err = nc.Subscribe("logger.token.revoke", revokeTokenListener); err != nil {
+		diag.Error(err.Error())
+	}
+
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		diag.Fatal(err)
+	}
+
+	if origins, err = originPolicyFromEnv(); err != nil {
+		diag.Fatal(err)
+	}
 
 	// Create new HTTP Server and add the route handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/logs", handler)
+	mux.HandleFunc("/", viewerHandler)
+	mux.HandleFunc("/logs", requireClientCert(tlsConfig, handler))
+	mux.HandleFunc("/logs/stream", requireClientCert(tlsConfig, sseHandler))
+	mux.HandleFunc("/logs/search", searchHandler)
+	mux.HandleFunc("/logs/context", contextHandler)
+	mux.HandleFunc("/logs/viewers", viewersHandler)
+	mux.HandleFunc("/logs/metrics", metricsHandler)
+	mux.HandleFunc("/adapters", adaptersHandler)
+	mux.HandleFunc("/adapters/", adaptersHandler)
 
 	// Subscribe to subjects
 	_, err = nc.Subscribe(">", natsHandler)
 	if err != nil {
-		log.Println(err)
+		diag.Error(err)
 		return
 	}
 
 	// Start Listening
-	addr := fmt.Sprintf("%s:%s", "", "22001")
-	_ = http.ListenAndServe(addr, mux)
+	if err := serve(newServer(mux, tlsConfig)); err != nil {
+		diag.Error(err.Error())
+	}
 
 	runtime.Goexit()
 }
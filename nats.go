@@ -7,11 +7,12 @@ package main
 import (
 	"encoding/json"
 
+	ads "github.com/ernestio/logger/adapters"
 	"github.com/nats-io/go-nats"
 )
 
 func natsHandler(msg *nats.Msg) {
-	if msg.Subject == "logger.log" {
+	if msg.Subject == "logger.log" || ads.Private(msg.Subject) {
 		return
 	}
 
@@ -20,11 +21,14 @@ func natsHandler(msg *nats.Msg) {
 	m := LogMessage{
 		Subject: msg.Subject,
 		Body:    body,
-		Level:   "debug",
+		Level:   ads.SubjectLevel(msg.Subject),
 		User:    "system",
 	}
 
-	data, _ := json.Marshal(m)
+	keep(m)
 
-	bc.Publish("logs", data)
+	// every broadcast event gets an ID so clients can resume from it
+	data, _ := json.Marshal(events.Add(m))
+
+	viewers.Publish(data)
 }
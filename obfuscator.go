@@ -28,7 +28,7 @@ type Datacenter struct {
 
 // Obfuscate : obfuscates sensible data on the given stack
 func Obfuscate(subject, stack string) string {
-	stack = PreProcess(stack)
+	stack = PreProcess(redact(stack))
 	if subject == "datacenter.set" || subject == "datacenter.del" {
 		patternsToObfuscate = make([]string, 0)
 	}
@@ -0,0 +1,105 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ernestio/logger/diag"
+)
+
+// OriginPolicy : browser origins allowed to open a websocket. Requests
+// without an Origin header don't come from a browser and are not
+// affected.
+type OriginPolicy struct {
+	// Allowed : origins such as https://ernest.example.com, the left-most
+	// host label can be a wildcard, as in https://*.example.com
+	Allowed []string
+	// Permissive : development mode, any origin is accepted
+	Permissive bool
+}
+
+// originPolicyFromEnv : allows the page's own origin and the ones in
+// ERNEST_ALLOWED_ORIGINS, or any origin when ERNEST_DEV_MODE is true
+func originPolicyFromEnv() (*OriginPolicy, error) {
+	p := OriginPolicy{
+		Allowed:    splitList(os.Getenv("ERNEST_ALLOWED_ORIGINS")),
+		Permissive: os.Getenv("ERNEST_DEV_MODE") == "true",
+	}
+
+	for _, o := range p.Allowed {
+		if err := validOrigin(o); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Permissive {
+		diag.Warn("Development mode, websockets are accepted from any origin")
+	}
+
+	return &p, nil
+}
+
+func validOrigin(o string) error {
+	u, err := url.Parse(o)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+		return errors.New("Invalid allowed origin '" + o + "', expected scheme://host[:port]")
+	}
+	if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+		return errors.New("Invalid allowed origin '" + o + "', only the left-most host label can be a wildcard")
+	}
+	return nil
+}
+
+// Check : upgrader's CheckOrigin, logging the rejected requests
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.Permissive {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range p.Allowed {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+
+	diag.Warn("Refused websocket from origin '" + origin + "' on " + r.RemoteAddr)
+	return false
+}
+
+// matchOrigin : compares an origin with an allowed one, which may have a
+// wildcard as its left-most host label
+func matchOrigin(allowed, origin string) bool {
+	a, err := url.Parse(allowed)
+	if err != nil {
+		return false
+	}
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if !strings.EqualFold(a.Scheme, o.Scheme) {
+		return false
+	}
+
+	if strings.HasPrefix(a.Host, "*.") {
+		suffix := strings.ToLower(a.Host[1:])
+		host := strings.ToLower(o.Host)
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+
+	return strings.EqualFold(a.Host, o.Host)
+}
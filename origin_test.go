@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://logger.example.com:22001/logs", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	Convey("Given an origin allowlist", t, func() {
+		p := OriginPolicy{Allowed: []string{"https://ernest.example.com", "https://*.ops.example.com"}}
+
+		Convey("it should accept exact and wildcard matches", func() {
+			So(p.Check(request("https://ernest.example.com")), ShouldBeTrue)
+			So(p.Check(request("https://web.ops.example.com")), ShouldBeTrue)
+			So(p.Check(request("https://a.b.ops.example.com")), ShouldBeTrue)
+		})
+
+		Convey("it should refuse other origins, schemes and ports", func() {
+			So(p.Check(request("https://evil.com")), ShouldBeFalse)
+			So(p.Check(request("http://ernest.example.com")), ShouldBeFalse)
+			So(p.Check(request("https://ernest.example.com:8443")), ShouldBeFalse)
+			So(p.Check(request("https://ops.example.com")), ShouldBeFalse)
+			So(p.Check(request("https://evilops.example.com")), ShouldBeFalse)
+		})
+
+		Convey("it should accept the logger's own origin and non browser clients", func() {
+			So(p.Check(request("http://logger.example.com:22001")), ShouldBeTrue)
+			So(p.Check(request("")), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given the development mode", t, func() {
+		p := OriginPolicy{Permissive: true}
+
+		Convey("it should accept any origin", func() {
+			So(p.Check(request("https://evil.com")), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given invalid allowed origins", t, func() {
+		Convey("they should be refused", func() {
+			So(validOrigin("ernest.example.com"), ShouldNotBeNil)
+			So(validOrigin("https://ernest.*.com"), ShouldNotBeNil)
+			So(validOrigin("https://ernest.example.com/logs"), ShouldNotBeNil)
+			So(validOrigin("https://*.example.com"), ShouldBeNil)
+		})
+	})
+}
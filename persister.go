@@ -7,10 +7,9 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"os"
 
-	"github.com/nats-io/go-nats"
+	"github.com/ernestio/logger/diag"
 )
 
 // Persistence : representation of the persisted file
@@ -20,7 +19,7 @@ type Persistence struct {
 	Rollbar  []byte `json:"rollbar"`
 }
 
-func persist(m *nats.Msg) {
+func persist(config []byte) {
 	var per Persistence
 	var adapter GenericAdapter
 	file := ".logger"
@@ -32,7 +31,7 @@ func persist(m *nats.Msg) {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		f, err := os.Create(file)
 		if err != nil {
-			log.Println("Can't create persistence file '" + file + "'")
+			diag.Error("Can't create persistence file '" + file + "'")
 			return
 		}
 		err = ioutil.WriteFile(file, []byte("{}"), 0644)
@@ -43,26 +42,26 @@ func persist(m *nats.Msg) {
 
 	dat, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Println("Error reading " + file + " file")
+		diag.Error("Error reading " + file + " file")
 		return
 	}
 	if err = json.Unmarshal(dat, &per); err != nil {
-		log.Println("Persistence file is corrupted")
+		diag.Error("Persistence file is corrupted")
 		return
 	}
 
-	if err := json.Unmarshal(m.Data, &adapter); err != nil {
-		log.Println("Error processing logger.set message")
-		log.Println(err.Error())
+	if err := json.Unmarshal(config, &adapter); err != nil {
+		diag.Error("Error processing logger.set message")
+		diag.Error(err.Error())
 	}
 
 	switch adapter.Type {
 	case "basic":
-		per.Basic = m.Data
+		per.Basic = config
 	case "logstash":
-		per.Logstash = m.Data
+		per.Logstash = config
 	case "rollbar":
-		per.Rollbar = m.Data
+		per.Rollbar = config
 	}
 
 	body, err := json.Marshal(per)
@@ -83,26 +82,21 @@ func load() error {
 
 	dat, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Println("Error reading '" + file + "' file")
+		diag.Error("Error reading '" + file + "' file")
 		return err
 	}
 	if err = json.Unmarshal(dat, &per); err != nil {
-		log.Println("Persistence file is corrupted")
+		diag.Error("Persistence file is corrupted")
 		return err
 	}
 
-	m := nats.Msg{}
-	if string(per.Basic) != "" {
-		m.Data = per.Basic
-		newBasicAdapterListener(&m)
-	}
-	if string(per.Logstash) != "" {
-		m.Data = per.Logstash
-		newLogstashAdapterListener(&m)
-	}
-	if string(per.Rollbar) != "" {
-		m.Data = per.Rollbar
-		newRollbarAdapterListener(&m)
+	for _, config := range [][]byte{per.Basic, per.Logstash, per.Rollbar} {
+		if len(config) == 0 {
+			continue
+		}
+		if _, err := setAdapter(config); err != nil {
+			diag.Error(err.Error())
+		}
 	}
 
 	return nil
@@ -0,0 +1,395 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/go-nats"
+)
+
+// Rejection reasons, also used as metric names
+const (
+	rejectConnectRate = "connection rate"
+	rejectAuthRate    = "authentication failures"
+	rejectUserRate    = "user connection rate"
+	rejectStreamCap   = "stream cap"
+	rejectUserCap     = "user connections"
+)
+
+// retryAfter : seconds a rate limited client is asked to wait
+const retryAfter = "60"
+
+var errTooManyRequests = errors.New("Too many requests")
+
+// sweepInterval : how often idle buckets are forgotten
+const sweepInterval = time.Minute
+
+// auditWindow : refusals of an address for the same reason are audited
+// once per window
+const auditWindow = time.Minute
+
+// Limiter : allows up to a number of events per minute for each key, as
+// a bucket refilled over the minute. A nil Limiter allows everything.
+type Limiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter : Limiter constructor, returning nil when perMinute is 0
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// Allow : takes a token from the key's bucket, if there is one left
+func (l *Limiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// Peek : checks the key has a token left without taking it
+func (l *Limiter) Peek(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.refill(key).tokens >= 1
+}
+
+// Len : returns the number of keys being tracked
+func (l *Limiter) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+// refill : returns the key's bucket with the tokens earned since it was
+// last used. The caller must hold the lock.
+func (l *Limiter) refill(key string) *bucket {
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	return b
+}
+
+// sweep : forgets the buckets that are full again, as they behave like
+// new ones. The caller must hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Limits : rate limits applied to the live log endpoints, and the count
+// of the connections they refused
+type Limits struct {
+	connect  *Limiter
+	failures *Limiter
+	users    *Limiter
+	rejected map[string]int64
+	refused  map[string]*refusals
+	swept    time.Time
+	mu       sync.Mutex
+}
+
+// refusals : refusals of an address for a reason since the last one
+// audited
+type refusals struct {
+	since   time.Time
+	skipped int64
+}
+
+// Metrics : current usage of the live log endpoints
+type Metrics struct {
+	Streams    int              `json:"streams"`
+	MaxStreams int              `json:"max_streams"`
+	Addresses  int              `json:"tracked_addresses"`
+	Users      int              `json:"tracked_users"`
+	Rejected   map[string]int64 `json:"rejected"`
+}
+
+// newLimitsFromEnv : allows ERNEST_CONNECT_RATE connections (60 by
+// default) and ERNEST_AUTH_FAILURE_RATE failed authentications (10 by
+// default) per minute from an address, and ERNEST_USER_CONNECT_RATE
+// connections per minute of a single user (30 by default). 0 disables a
+// limit.
+func newLimitsFromEnv() *Limits {
+	return &Limits{
+		connect:  NewLimiter(envInt("ERNEST_CONNECT_RATE", 60)),
+		failures: NewLimiter(envInt("ERNEST_AUTH_FAILURE_RATE", 10)),
+		users:    NewLimiter(envInt("ERNEST_USER_CONNECT_RATE", 30)),
+		rejected: make(map[string]int64),
+	}
+}
+
+func envInt(key string, def int) int {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		diag.Warn("Invalid " + key + " '" + s + "', using " + strconv.Itoa(def))
+		return def
+	}
+
+	return n
+}
+
+// admit : checks a new connection from the given address, returning why
+// it is refused, if it is
+func (l *Limits) admit(addr string) string {
+	if l == nil {
+		return ""
+	}
+	if !l.failures.Peek(addr) {
+		return rejectAuthRate
+	}
+	if !l.connect.Allow(addr) {
+		return rejectConnectRate
+	}
+	return ""
+}
+
+// blocked : checks if the given address is over its authentication
+// failure rate
+func (l *Limits) blocked(addr string) bool {
+	return l != nil && !l.failures.Peek(addr)
+}
+
+// failed : counts a failed authentication from the given address
+func (l *Limits) failed(addr string) {
+	if l == nil {
+		return
+	}
+	l.failures.Allow(addr)
+}
+
+// user : checks an authenticated user can open a new connection
+func (l *Limits) user(username string) string {
+	if l == nil || l.users.Allow(username) {
+		return ""
+	}
+	return rejectUserRate
+}
+
+func (l *Limits) reject(reason string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejected[reason]++
+}
+
+// audit : tells if a refusal should be audited, being the first of its
+// address and reason over the window, and how many were left out since
+// the last one audited
+func (l *Limits) audit(addr, reason string) (bool, int64) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.refused == nil {
+		l.refused = make(map[string]*refusals)
+	}
+	if now.Sub(l.swept) >= auditWindow {
+		l.swept = now
+		for key, r := range l.refused {
+			if now.Sub(r.since) >= 2*auditWindow {
+				delete(l.refused, key)
+			}
+		}
+	}
+
+	key := addr + " " + reason
+	r, ok := l.refused[key]
+	if ok && now.Sub(r.since) < auditWindow {
+		r.skipped++
+		return false, 0
+	}
+
+	var skipped int64
+	if ok {
+		skipped = r.skipped
+	}
+	l.refused[key] = &refusals{since: now}
+
+	return true, skipped
+}
+
+// Metrics : returns the current usage
+func (l *Limits) Metrics(h *Hub) Metrics {
+	m := Metrics{
+		Streams:    h.Len(),
+		MaxStreams: h.max,
+		Rejected:   make(map[string]int64),
+	}
+	if l == nil {
+		return m
+	}
+
+	m.Addresses = l.connect.Len()
+	m.Users = l.users.Len()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for reason, n := range l.rejected {
+		m.Rejected[reason] = n
+	}
+
+	return m
+}
+
+// admit : refuses, before upgrading or streaming, the connections over
+// the rate of their address, from addresses with too many failed
+// authentications, or above the global stream cap
+func admit(w http.ResponseWriter, r *http.Request, transport string) bool {
+	reason := limits.admit(host(r.RemoteAddr))
+	if reason == "" && viewers.Full() {
+		reason = rejectStreamCap
+	}
+	if reason == "" {
+		return true
+	}
+
+	refuse(r, transport, reason, nil)
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+
+	return false
+}
+
+// authorize : checks the admin token of a plain http request. Addresses
+// with too many failed authentications are refused before their token is
+// checked, and every failure counts towards that limit. On error, the
+// status the request should be answered with is returned.
+func authorize(w http.ResponseWriter, r *http.Request) (string, int, error) {
+	addr := host(r.RemoteAddr)
+	if limits.blocked(addr) {
+		refuse(r, "http", rejectAuthRate, nil)
+		w.Header().Set("Retry-After", retryAfter)
+		return "", http.StatusTooManyRequests, errTooManyRequests
+	}
+
+	username, err := validateToken(requestToken(r))
+	if err != nil {
+		limits.failed(addr)
+		auditDenied(r, "http", err.Error(), nil)
+		return "", http.StatusUnauthorized, errUnauthorized
+	}
+
+	return username, 0, nil
+}
+
+// refuse : counts a refused connection, and audits it unless its
+// address was already refused for the same reason within the window, so
+// a flood of connections doesn't turn into a flood of audit events
+func refuse(r *http.Request, transport, reason string, s *Session) {
+	limits.reject(reason)
+
+	ok, skipped := limits.audit(host(r.RemoteAddr), reason)
+	if !ok {
+		return
+	}
+
+	diag.Warn("Refused " + transport + " connection from " + r.RemoteAddr + ": " + reason)
+	auditRefused(r, transport, reason, s, skipped)
+}
+
+// closeTryLater : tells a websocket client it was refused, and to try
+// again later
+func closeTryLater(c *websocket.Conn, status string) {
+	_ = c.WriteMessage(websocket.TextMessage, []byte(`{"status": "`+status+`"}`))
+	msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, status)
+	_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
+var metricsListener = func(m *nats.Msg) {
+	reply(m, limits.Metrics(viewers), nil)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, status, err := authorize(w, r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, limits.Metrics(viewers))
+}
@@ -0,0 +1,145 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimiter(t *testing.T) {
+	Convey("Given a limiter allowing two events per minute", t, func() {
+		l := NewLimiter(2)
+
+		Convey("a key should be refused once its bucket is empty", func() {
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			So(l.Peek("10.0.0.1"), ShouldBeFalse)
+			So(l.Allow("10.0.0.1"), ShouldBeFalse)
+
+			So(l.Allow("10.0.0.2"), ShouldBeTrue)
+		})
+
+		Convey("the bucket should be refilled over time", func() {
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			l.buckets["10.0.0.1"].last = time.Now().Add(-31 * time.Second)
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			So(l.Allow("10.0.0.1"), ShouldBeFalse)
+		})
+
+		Convey("full buckets should be forgotten", func() {
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			l.buckets["10.0.0.1"].last = time.Now().Add(-time.Minute)
+			l.swept = time.Now().Add(-2 * sweepInterval)
+			So(l.Allow("10.0.0.2"), ShouldBeTrue)
+			So(l.Len(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a disabled limiter", t, func() {
+		l := NewLimiter(0)
+
+		Convey("everything should be allowed", func() {
+			So(l.Allow("10.0.0.1"), ShouldBeTrue)
+			So(l.Peek("10.0.0.1"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given limits on failed authentications", t, func() {
+		l := &Limits{failures: NewLimiter(1), rejected: make(map[string]int64)}
+
+		Convey("an address should be refused after failing too often", func() {
+			So(l.admit("10.0.0.1"), ShouldEqual, "")
+			l.failed("10.0.0.1")
+			So(l.admit("10.0.0.1"), ShouldEqual, rejectAuthRate)
+			So(l.admit("10.0.0.2"), ShouldEqual, "")
+		})
+	})
+
+	Convey("Given admin http handlers and limits on failed authentications", t, func() {
+		previousLimits, previousTokens := limits, apitokens
+		defer func() { limits, apitokens = previousLimits, previousTokens }()
+		apitokens = nil
+
+		handlers := map[string]http.HandlerFunc{
+			"/logs/search":  searchHandler,
+			"/logs/context": contextHandler,
+			"/logs/viewers": viewersHandler,
+			"/logs/metrics": metricsHandler,
+			"/adapters":     adaptersHandler,
+		}
+
+		for path, h := range handlers {
+			limits = &Limits{failures: NewLimiter(2), rejected: make(map[string]int64)}
+
+			Convey("an address failing too often should be refused on "+path, func() {
+				status := func() *httptest.ResponseRecorder {
+					w := httptest.NewRecorder()
+					r := httptest.NewRequest(http.MethodGet, path+"?token="+apiTokenPrefix+"x_y", nil)
+					r.RemoteAddr = "10.0.0.1:1234"
+					h(w, r)
+					return w
+				}
+
+				So(status().Code, ShouldEqual, http.StatusUnauthorized)
+				So(status().Code, ShouldEqual, http.StatusUnauthorized)
+
+				w := status()
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(w.Header().Get("Retry-After"), ShouldEqual, retryAfter)
+			})
+		}
+	})
+
+	Convey("Given refused connections", t, func() {
+		previousLimits, previousAudit := limits, audit
+		defer func() { limits, audit = previousLimits, previousAudit }()
+		limits = &Limits{rejected: make(map[string]int64)}
+		audit = NewAudit(nil, 10)
+
+		refused := func(addr string) {
+			r := httptest.NewRequest(http.MethodGet, "/logs/stream", nil)
+			r.RemoteAddr = addr
+			refuse(r, "sse", rejectConnectRate, nil)
+		}
+
+		Convey("they should be audited once per address and window", func() {
+			for i := 0; i < 3; i++ {
+				refused("10.0.0.1:1234")
+			}
+			refused("10.0.0.2:1234")
+
+			So(limits.rejected[rejectConnectRate], ShouldEqual, 4)
+			So(audit.Find(&AuditQuery{RemoteAddr: "10.0.0.1"}), ShouldHaveLength, 1)
+			So(audit.Find(&AuditQuery{RemoteAddr: "10.0.0.2"}), ShouldHaveLength, 1)
+
+			limits.refused["10.0.0.1 "+rejectConnectRate].since = time.Now().Add(-auditWindow)
+			refused("10.0.0.1:1234")
+
+			records := audit.Find(&AuditQuery{RemoteAddr: "10.0.0.1"})
+			So(records, ShouldHaveLength, 2)
+			So(records[1].Skipped, ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a hub limited to two streams", t, func() {
+		h := NewHub(0, 1)
+		h.max = 2
+		_, _ = h.Join("alice", "", "10.0.0.1:1234", "websocket")
+		_, _ = h.Join("bob", "", "10.0.0.2:1234", "sse")
+
+		Convey("a third one should be refused", func() {
+			So(h.Full(), ShouldBeTrue)
+			_, err := h.Join("carol", "", "10.0.0.3:1234", "websocket")
+			So(err, ShouldEqual, ErrTooManyStreams)
+			So((&Limits{}).Metrics(h).Streams, ShouldEqual, 2)
+		})
+	})
+}
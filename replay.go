@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// Gap : notice sent to a reconnecting client when some of the events it
+// missed are no longer retained
+type Gap struct {
+	Status      string `json:"status"`
+	LastEventID string `json:"last_event_id"`
+}
+
+// replay : sends a reconnecting client the events published after the
+// last one it received, returning the ID of the last event replayed
+func replay(out streamWriter, s *Session) (uint64, error) {
+	records, id, gap, err := events.Resume(*s.EventID)
+	if err != nil {
+		_ = out.notice("status", []byte(`{"status": "bad request"}`))
+		return 0, err
+	}
+
+	if gap {
+		data, _ := json.Marshal(Gap{Status: "gap", LastEventID: *s.EventID})
+		if err := out.notice("gap", data); err != nil {
+			return 0, err
+		}
+	}
+
+	last := id
+	if len(records) > 0 || gap {
+		last = 0
+	}
+
+	for _, r := range records {
+		last = r.ID
+		if !s.Match(&r.LogMessage) {
+			continue
+		}
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		if err := out.event(r.EventID, data); err != nil {
+			return 0, err
+		}
+	}
+
+	return last, nil
+}
+
+// backlog : sends a new client the last retained events matching its
+// filter, marked as historical, returning the ID of the last one sent
+func backlog(out streamWriter, s *Session) (uint64, error) {
+	// the scope is checked after the filter, so the limit is applied last
+	f := s.Filter
+	f.Limit = 0
+
+	var records []Record
+	for _, r := range events.Query(&f) {
+		if s.Identity.Allows(&r.LogMessage) {
+			records = append(records, r)
+		}
+	}
+	if len(records) > s.Backlog {
+		records = records[len(records)-s.Backlog:]
+	}
+
+	var last uint64
+	for _, r := range records {
+		last = r.ID
+		r.Historical = true
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		if err := out.event(r.EventID, data); err != nil {
+			return 0, err
+		}
+	}
+
+	return last, nil
+}
@@ -0,0 +1,138 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultScopeRules : claims a token can carry to limit what a non-admin
+// user sees, each with the body fields holding the matching IDs
+var defaultScopeRules = map[string][]string{
+	"groups":       {"group_id"},
+	"projects":     {"project_id", "datacenter_id"},
+	"environments": {"environment_id", "service_id", "service"},
+}
+
+// scopeRules : rules in use, replaced by the JSON object in
+// ERNEST_SCOPE_RULES when set
+var scopeRules = defaultScopeRules
+
+// Identity : who a token belongs to and what it is allowed to see
+type Identity struct {
+	Username string
+	Admin    bool
+	// Scope : IDs allowed for each scope claim, only used for non-admins
+	Scope map[string][]string
+	// Filter : fixed filter applied on top of the session's, if any
+	Filter *Filter
+	// TokenID : id of the API token the identity comes from, if any
+	TokenID string
+}
+
+func setupScopeRules() error {
+	rules := os.Getenv("ERNEST_SCOPE_RULES")
+	if rules == "" {
+		return nil
+	}
+
+	r := make(map[string][]string)
+	if err := json.Unmarshal([]byte(rules), &r); err != nil {
+		return errors.New("Invalid ERNEST_SCOPE_RULES: " + err.Error())
+	}
+	scopeRules = r
+
+	return nil
+}
+
+// identityFromClaims : builds the identity for a validated token. Tokens
+// that are neither admin nor scoped to anything are refused.
+func identityFromClaims(claims jwt.MapClaims) (*Identity, error) {
+	id := Identity{Scope: make(map[string][]string)}
+	id.Username, _ = claims["username"].(string)
+	id.Admin, _ = claims["admin"].(bool)
+
+	if id.Admin {
+		return &id, nil
+	}
+
+	for claim := range scopeRules {
+		if ids := claimList(claims[claim]); len(ids) > 0 {
+			id.Scope[claim] = ids
+		}
+	}
+
+	if len(id.Scope) == 0 {
+		return nil, errUnauthorized
+	}
+
+	return &id, nil
+}
+
+// claimList : reads a claim given as a single value or a list of them
+func claimList(v interface{}) []string {
+	var list []string
+
+	switch c := v.(type) {
+	case []interface{}:
+		for _, item := range c {
+			list = append(list, claimList(item)...)
+		}
+	default:
+		if s := idString(c); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// idString : IDs may be sent as strings or numbers
+func idString(v interface{}) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	return ""
+}
+
+// Allows : checks the identity can see the given message. Admins see
+// everything their filter selects, other users only the messages
+// carrying one of their IDs.
+func (id *Identity) Allows(m *LogMessage) bool {
+	if id == nil {
+		return false
+	}
+	if id.Filter != nil && !id.Filter.Match(m) {
+		return false
+	}
+	if id.Admin {
+		return true
+	}
+
+	for _, body := range []string{m.Body, m.Message} {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &fields); err != nil {
+			continue
+		}
+
+		for claim, allowed := range id.Scope {
+			for _, key := range scopeRules[claim] {
+				if v := idString(fields[key]); v != "" && matchAny(allowed, v, equals) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
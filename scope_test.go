@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScope(t *testing.T) {
+	Convey("Given the claims of an admin token", t, func() {
+		id, err := identityFromClaims(jwt.MapClaims{"username": "admin", "admin": true})
+
+		Convey("it should see every message", func() {
+			So(err, ShouldBeNil)
+			So(id.Allows(&LogMessage{Body: "plain text"}), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given the claims of a user scoped to some groups and environments", t, func() {
+		id, err := identityFromClaims(jwt.MapClaims{
+			"username":     "bob",
+			"groups":       []interface{}{"g1", float64(2)},
+			"environments": "e1",
+		})
+		So(err, ShouldBeNil)
+		So(id.Admin, ShouldBeFalse)
+
+		Convey("it should only see messages carrying one of its IDs", func() {
+			So(id.Allows(&LogMessage{Body: `{"group_id":"g1"}`}), ShouldBeTrue)
+			So(id.Allows(&LogMessage{Body: `{"group_id":2}`}), ShouldBeTrue)
+			So(id.Allows(&LogMessage{Message: `{"service_id":"e1"}`}), ShouldBeTrue)
+			So(id.Allows(&LogMessage{Body: `{"group_id":"g3"}`}), ShouldBeFalse)
+			So(id.Allows(&LogMessage{Body: `{"project_id":"g1"}`}), ShouldBeFalse)
+			So(id.Allows(&LogMessage{Body: "plain text"}), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given the claims of a user without admin or scope claims", t, func() {
+		_, err := identityFromClaims(jwt.MapClaims{"username": "bob", "admin": "yes"})
+
+		Convey("it should be refused", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given no identity", t, func() {
+		var id *Identity
+
+		Convey("it should see nothing", func() {
+			So(id.Allows(&LogMessage{Body: `{"group_id":"g1"}`}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,235 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+	"github.com/ernestio/logger/store"
+	"github.com/nats-io/go-nats"
+)
+
+var errStoreDisabled = errors.New("Log store is not enabled")
+
+func setupStore() {
+	c := store.ConfigFromEnv()
+	if c.Dir == "" {
+		return
+	}
+
+	s, err := store.Open(c)
+	if err != nil {
+		diag.Error("Could not open log store on '" + c.Dir + "'")
+		diag.Error(err.Error())
+		return
+	}
+	logstore = s
+}
+
+func search(q *store.Query) ([]store.Record, error) {
+	if logstore == nil {
+		return nil, errStoreDisabled
+	}
+	return logstore.Search(q)
+}
+
+// MatchRequest : full-text query sent on logger.match
+type MatchRequest struct {
+	Match string `json:"match"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// ContextRequest : record and number of neighbours sent on logger.context
+type ContextRequest struct {
+	ID     uint64 `json:"id"`
+	Before int    `json:"before,omitempty"`
+	After  int    `json:"after,omitempty"`
+}
+
+func recordContext(c *ContextRequest) ([]store.Record, error) {
+	if logstore == nil {
+		return nil, errStoreDisabled
+	}
+	return logstore.Context(c.ID, c.Before, c.After)
+}
+
+// reply : answers a store request with the given value or error
+func reply(m *nats.Msg, v interface{}, err error) {
+	var body []byte
+	if err == nil {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		diag.Error(err.Error())
+		body, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+
+	if err := nc.Publish(m.Reply, body); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+func invalidRequest(m *nats.Msg, err error) {
+	diag.Error(err.Error())
+	if err := nc.Publish(m.Reply, []byte(`{"error":"Invalid request"}`)); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+var matchListener = func(m *nats.Msg) {
+	var r MatchRequest
+	if err := json.Unmarshal(m.Data, &r); err != nil {
+		invalidRequest(m, err)
+		return
+	}
+
+	if logstore == nil {
+		reply(m, nil, errStoreDisabled)
+		return
+	}
+
+	ids, err := logstore.Match(r.Match, r.Limit)
+	reply(m, map[string][]uint64{"ids": ids}, err)
+}
+
+var contextListener = func(m *nats.Msg) {
+	var c ContextRequest
+	if err := json.Unmarshal(m.Data, &c); err != nil {
+		invalidRequest(m, err)
+		return
+	}
+
+	records, err := recordContext(&c)
+	reply(m, records, err)
+}
+
+var searchListener = func(m *nats.Msg) {
+	var q store.Query
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &q); err != nil {
+			invalidRequest(m, err)
+			return
+		}
+	}
+
+	records, err := search(&q)
+	reply(m, records, err)
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, status, err := authorize(w, r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := search(q)
+	if err != nil {
+		searchError(w, err)
+		return
+	}
+
+	writeJSON(w, records)
+}
+
+func contextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, status, err := authorize(w, r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var c ContextRequest
+	var err error
+	v := r.URL.Query()
+
+	if c.ID, err = strconv.ParseUint(v.Get("id"), 10, 64); err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	c.Before, _ = strconv.Atoi(v.Get("before"))
+	c.After, _ = strconv.Atoi(v.Get("after"))
+
+	records, err := recordContext(&c)
+	if err != nil {
+		searchError(w, err)
+		return
+	}
+
+	writeJSON(w, records)
+}
+
+func searchError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case store.QueryError:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case errStoreDisabled, store.ErrNoIndex, store.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		diag.Error(err.Error())
+		_ = internalerror(w)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+func parseQuery(v url.Values) (*store.Query, error) {
+	var err error
+
+	q := store.Query{
+		Subject: v.Get("subject"),
+		Level:   v.Get("level"),
+		User:    v.Get("user"),
+		Text:    v.Get("q"),
+		Match:   v.Get("match"),
+	}
+
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, errors.New("Invalid 'from' time, expected RFC3339")
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, errors.New("Invalid 'to' time, expected RFC3339")
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return nil, errors.New("Invalid limit")
+		}
+	}
+
+	return &q, nil
+}
@@ -0,0 +1,195 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+)
+
+const (
+	// defaultListen : address the HTTP server listens on by default
+	defaultListen = ":22001"
+	// certReload : how often the TLS certificate files are checked for
+	// changes
+	certReload = 30 * time.Second
+	// readHeaderWait : time allowed to a client to send its request headers
+	readHeaderWait = 10 * time.Second
+)
+
+// certificate : TLS certificate reloaded whenever its files change, so
+// renewed certificates are used without restarting the logger
+type certificate struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modified time.Time
+	mu       sync.RWMutex
+}
+
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *certificate) load() error {
+	modified := c.lastModified()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.New("Could not load TLS certificate: " + err.Error())
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modified = modified
+	c.mu.Unlock()
+
+	return nil
+}
+
+// lastModified : returns the most recent change of the certificate or key
+func (c *certificate) lastModified() time.Time {
+	var last time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// get : hands the current certificate to new TLS connections
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// watch : reloads the certificate when its files change, keeping the
+// current one if the new files can't be loaded
+func (c *certificate) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		c.mu.RLock()
+		modified := c.modified
+		c.mu.RUnlock()
+
+		if !c.lastModified().After(modified) {
+			continue
+		}
+
+		if err := c.load(); err != nil {
+			diag.Error(err.Error())
+			continue
+		}
+		diag.Info("TLS certificate reloaded from '" + c.certFile + "'")
+	}
+}
+
+// tlsConfigFromEnv : serves TLS with the certificate and key in
+// ERNEST_TLS_CERT and ERNEST_TLS_KEY. Client certificates signed by the
+// CAs in ERNEST_TLS_CLIENT_CA are verified when given, and required to
+// follow the live logs. Returns nil when TLS is not configured.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certFile := os.Getenv("ERNEST_TLS_CERT")
+	keyFile := os.Getenv("ERNEST_TLS_KEY")
+	caFile := os.Getenv("ERNEST_TLS_CLIENT_CA")
+
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, errors.New("ERNEST_TLS_CLIENT_CA requires ERNEST_TLS_CERT and ERNEST_TLS_KEY")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("Both ERNEST_TLS_CERT and ERNEST_TLS_KEY are required to serve TLS")
+	}
+
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go cert.watch(certReload)
+
+	c := tls.Config{
+		GetCertificate: cert.get,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.New("Could not read client CA: " + err.Error())
+		}
+
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found on client CA '" + caFile + "'")
+		}
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return &c, nil
+}
+
+// requireClientCert : refuses requests without a verified client
+// certificate when client certificates are configured
+func requireClientCert(c *tls.Config, h http.HandlerFunc) http.HandlerFunc {
+	if c == nil || c.ClientCAs == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			diag.Warn("Refused connection without client certificate from " + r.RemoteAddr)
+			http.Error(w, "A valid client certificate is required", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// newServer : HTTP server listening on ERNEST_LISTEN (:22001 by default)
+func newServer(h http.Handler, c *tls.Config) *http.Server {
+	addr := os.Getenv("ERNEST_LISTEN")
+	if addr == "" {
+		addr = defaultListen
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		TLSConfig:         c,
+		ReadHeaderTimeout: readHeaderWait,
+		// HTTP/2 is left out as its connections can't be hijacked, which
+		// event streams need to set write deadlines
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+}
+
+func serve(s *http.Server) error {
+	if s.TLSConfig != nil {
+		diag.Info("Listening on " + s.Addr + " with TLS")
+		return s.ListenAndServeTLS("", "")
+	}
+
+	diag.Info("Listening on " + s.Addr)
+	return s.ListenAndServe()
+}
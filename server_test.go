@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeCertificate(certFile, keyFile, name string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestServer(t *testing.T) {
+	Convey("Given a TLS certificate", t, func() {
+		dir, _ := ioutil.TempDir("", "tls")
+		defer func() { _ = os.RemoveAll(dir) }()
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		writeCertificate(certFile, keyFile, "first")
+
+		c, err := loadCertificate(certFile, keyFile)
+		So(err, ShouldBeNil)
+
+		Convey("it should be replaced when its files change", func() {
+			writeCertificate(certFile, keyFile, "second")
+			So(c.load(), ShouldBeNil)
+
+			cert, _ := c.get(nil)
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			So(leaf.Subject.CommonName, ShouldEqual, "second")
+		})
+
+		Convey("it should be kept when the new files are broken", func() {
+			_ = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+			So(c.load(), ShouldNotBeNil)
+
+			cert, _ := c.get(nil)
+			So(cert, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a handler requiring client certificates", t, func() {
+		h := requireClientCert(&tls.Config{ClientCAs: x509.NewCertPool()}, func(w http.ResponseWriter, r *http.Request) {})
+
+		Convey("requests without a verified certificate should be refused", func() {
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest("GET", "/logs", nil))
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+
+			w = httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/logs", nil)
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+			h(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
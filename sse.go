@@ -0,0 +1,205 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+)
+
+// sseWriter : writes to a Server-Sent Events client over its hijacked
+// connection, so every write can be given a deadline
+type sseWriter struct {
+	conn net.Conn
+	sent int64
+}
+
+func (w *sseWriter) event(id string, data []byte) error {
+	return w.write("id: "+id+"\n", data)
+}
+
+func (w *sseWriter) notice(kind string, data []byte) error {
+	return w.write("event: "+kind+"\n", data)
+}
+
+// ping : sends a comment line, ignored by clients, to keep the
+// connection alive and find out about dead ones
+func (w *sseWriter) ping() error {
+	return w.send([]byte(": ping\n\n"))
+}
+
+func (w *sseWriter) evict(reason string) error {
+	data, _ := json.Marshal(map[string]string{"status": "evicted", "reason": reason})
+	return w.notice("evicted", data)
+}
+
+func (w *sseWriter) write(header string, data []byte) error {
+	var b bytes.Buffer
+	b.WriteString(header)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	return w.send(b.Bytes())
+}
+
+// send : writes to the client, failing when it doesn't accept the data
+// within writeWait, so a stalled client can't hold its stream forever
+func (w *sseWriter) send(p []byte) error {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	n, err := w.conn.Write(p)
+	w.sent += int64(n)
+	return err
+}
+
+// hijack : takes over the client connection and writes the stream
+// response headers, returning a channel closed when the client goes away
+func hijack(w http.ResponseWriter) (net.Conn, <-chan struct{}, error) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "close")
+
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 200 OK\r\n")
+	_ = h.Write(&b)
+	b.WriteString("\r\n")
+
+	_ = conn.SetDeadline(time.Time{})
+	if err := conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	// clients don't send anything else, so reading only ends when the
+	// connection is closed
+	gone := make(chan struct{})
+	go func(r *bufio.Reader) {
+		_, _ = io.Copy(ioutil.Discard, r)
+		close(gone)
+	}(rw.Reader)
+
+	return conn, gone, nil
+}
+
+// sseHandler : streams the live logs as text/event-stream. Clients
+// authenticate with a bearer token or the token query parameter, and
+// resume with the Last-Event-ID header.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := w.(http.Hijacker); !ok {
+		_ = internalerror(w)
+		return
+	}
+
+	if !admit(w, r, "sse") {
+		return
+	}
+
+	session := Session{Filter: filterFromQuery(r.URL.Query())}
+
+	identity, err := parseToken(requestToken(r))
+	if err != nil {
+		limits.failed(host(r.RemoteAddr))
+		auditDenied(r, "sse", err.Error(), &session)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	session.Username = identity.Username
+	session.Authenticated = true
+	session.Identity = identity
+
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	if id != "" {
+		session.EventID = &id
+	}
+	session.Backlog, _ = strconv.Atoi(r.URL.Query().Get("backlog"))
+
+	if reason := limits.user(session.Username); reason != "" {
+		refuse(r, "sse", reason, &session)
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	v, err := register(&session, r, "sse")
+	if err != nil {
+		refuse(r, "sse", capReason(err), &session)
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	conn, gone, err := hijack(w)
+	if err != nil {
+		viewers.Leave(v)
+		diag.Error(err.Error())
+		return
+	}
+	auditConnected(v, &session)
+
+	out := &sseWriter{conn: conn}
+	defer func() {
+		_ = conn.Close()
+		viewers.Leave(v)
+		auditDisconnected(v, &session, out.sent)
+	}()
+
+	_ = stream(out, &session, v, nil, gone)
+}
+
+// filterFromQuery : builds a filter from the subjects, levels and
+// services query parameters, given as comma separated lists
+func filterFromQuery(v url.Values) Filter {
+	return Filter{
+		Subjects: queryList(v, "subjects"),
+		Levels:   queryList(v, "levels"),
+		Services: queryList(v, "services"),
+	}
+}
+
+func queryList(v url.Values, key string) []string {
+	var list []string
+	for _, value := range v[key] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSSEHandler(t *testing.T) {
+	Convey("Given an event stream server", t, func() {
+		dir, _ := ioutil.TempDir("", "tokens")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		previousTokens, previousEvents, previousViewers := apitokens, events, viewers
+		defer func() { apitokens, events, viewers = previousTokens, previousEvents, previousViewers }()
+
+		var err error
+		apitokens, err = OpenTokenStore(filepath.Join(dir, ".tokens"))
+		So(err, ShouldBeNil)
+		created, err := apitokens.Create(APIToken{Name: "siem", Admin: true})
+		So(err, ShouldBeNil)
+
+		events = NewRingBuffer(10, 0)
+		viewers = NewHub(0, 0)
+		r := events.Add(LogMessage{Subject: "instance.create", Level: "info"})
+
+		srv := httptest.NewServer(http.HandlerFunc(sseHandler))
+		defer srv.Close()
+
+		Convey("a client should get the stream over its own connection", func() {
+			resp, err := http.Get(srv.URL + "/?backlog=10&token=" + created.Token)
+			So(err, ShouldBeNil)
+
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			So(err, ShouldBeNil)
+			So(line, ShouldEqual, "id: "+r.EventID+"\n")
+			So(viewers.Len(), ShouldEqual, 1)
+
+			Convey("and leave once it goes away", func() {
+				_ = resp.Body.Close()
+				for i := 0; i < 100 && viewers.Len() > 0; i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(viewers.Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("a client whose token is revoked should be evicted", func() {
+			resp, err := http.Get(srv.URL + "/?token=" + created.Token)
+			So(err, ShouldBeNil)
+			defer func() { _ = resp.Body.Close() }()
+
+			for i := 0; i < 100 && viewers.Len() == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(viewers.List()[0].TokenID, ShouldEqual, created.ID)
+			So(revokeToken(created.ID), ShouldBeNil)
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			So(string(body), ShouldContainSubstring, "event: evicted")
+			So(string(body), ShouldContainSubstring, tokenRevoked)
+			So(viewers.Len(), ShouldEqual, 0)
+		})
+
+		Convey("a client without a valid token should be refused", func() {
+			resp, err := http.Get(srv.URL + "/?token=" + apiTokenPrefix + "x_y")
+			So(err, ShouldBeNil)
+			_ = resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,227 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrNoIndex : returned on full-text queries when the index is disabled
+var ErrNoIndex = errors.New("Full-text index is not enabled")
+
+// QueryError : returned when a full-text query can't be parsed
+type QueryError string
+
+func (e QueryError) Error() string {
+	return string(e)
+}
+
+// terms : inverted index of a segment, mapping every token to the
+// ascending positions of the entries it appears on
+type terms map[string][]int
+
+func (t terms) add(pos int, text string) {
+	for _, token := range tokenize(text) {
+		p := t[token]
+		if len(p) > 0 && p[len(p)-1] == pos {
+			continue
+		}
+		t[token] = append(p, pos)
+	}
+}
+
+// isTokenRune : tokens keep the characters found on names, IPs and
+// addresses together, so "web-1" or "10.0.0.1" can be searched as a whole
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(".-_:", r)
+}
+
+func isSeparator(r rune) bool {
+	return strings.ContainsRune(".-_:", r)
+}
+
+// words : splits text into lower cased words, without their parts
+func words(text string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isTokenRune(r) }) {
+		if w = strings.Trim(w, ".-_:"); w != "" {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// tokenize : returns every word on the text along with its parts, so
+// "web-1.example.com" can also be found by "example"
+func tokenize(text string) []string {
+	var tokens []string
+	for _, w := range words(text) {
+		tokens = append(tokens, w)
+		parts := strings.FieldsFunc(w, isSeparator)
+		if len(parts) > 1 {
+			tokens = append(tokens, parts...)
+		}
+	}
+	return tokens
+}
+
+// term : a single word or a quoted phrase of a full-text query
+type term struct {
+	tokens []string
+	phrase string
+}
+
+// matchQuery : a parsed full-text query. Terms on a clause must all be
+// found, any clause is enough for a record to match.
+type matchQuery [][]term
+
+// parseMatch : parses queries such as `web-1 AND "connection refused" OR
+// 10.0.0.1`. AND is implicit between terms and binds tighter than OR.
+func parseMatch(s string) (matchQuery, error) {
+	var q matchQuery
+	var clause []term
+
+	for len(s) > 0 {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+
+		var word string
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, QueryError("Unterminated phrase on full-text query")
+			}
+			phrase := s[1 : end+1]
+			s = s[end+2:]
+			if tokens := words(phrase); len(tokens) > 0 {
+				clause = append(clause, term{tokens: tokens, phrase: strings.ToLower(phrase)})
+			}
+			continue
+		}
+
+		if end := strings.IndexFunc(s, unicode.IsSpace); end < 0 {
+			word, s = s, ""
+		} else {
+			word, s = s[:end], s[end:]
+		}
+
+		switch word {
+		case "AND":
+		case "OR":
+			if len(clause) > 0 {
+				q = append(q, clause)
+			}
+			clause = nil
+		default:
+			if tokens := words(word); len(tokens) > 0 {
+				clause = append(clause, term{tokens: tokens})
+			}
+		}
+	}
+
+	if len(clause) > 0 {
+		q = append(q, clause)
+	}
+	if len(q) == 0 {
+		return nil, QueryError("Empty full-text query")
+	}
+
+	return q, nil
+}
+
+// candidates : positions of the entries on the segment matching the
+// query, in descending order. Phrases still need to be checked against
+// the record text.
+func (q matchQuery) candidates(t terms) []int {
+	found := make(map[int]bool)
+
+	for _, clause := range q {
+		var positions []int
+		first := true
+		for _, tm := range clause {
+			for _, token := range tm.tokens {
+				if first {
+					positions = t[token]
+					first = false
+				} else {
+					positions = intersect(positions, t[token])
+				}
+			}
+		}
+		for _, p := range positions {
+			found[p] = true
+		}
+	}
+
+	out := make([]int, 0, len(found))
+	for p := range found {
+		out = append(out, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+
+	return out
+}
+
+// matchText : checks the phrases of any clause appear on the text
+func (q matchQuery) matchText(text string) bool {
+	text = strings.Join(words(text), " ")
+
+	for _, clause := range q {
+		ok := true
+		for _, tm := range clause {
+			if tm.phrase == "" {
+				if !containsAll(text, tm.tokens) {
+					ok = false
+					break
+				}
+				continue
+			}
+			if !strings.Contains(text, strings.Join(tm.tokens, " ")) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAll(text string, tokens []string) bool {
+	for _, t := range tokens {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func intersect(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+func recordText(r *Record) string {
+	return r.Message + " " + r.Body
+}
@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFullTextIndex(t *testing.T) {
+	Convey("Given a store with a full-text index", t, func() {
+		dir, _ := ioutil.TempDir("", "store")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		s, err := Open(Config{Dir: dir, SegmentSize: 300, FullText: true})
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		for _, body := range []string{
+			`{"name":"web-1","ip":"10.0.0.1"}`,
+			`{"error":"connection refused by web-2.example.com"}`,
+			`{"name":"db-1","ip":"10.0.0.2"}`,
+			`{"error":"refused connection"}`,
+		} {
+			_, err := s.Append(Record{Subject: "x", Body: body})
+			So(err, ShouldBeNil)
+		}
+
+		Convey("names and IPs should be found as a whole", func() {
+			ids, err := s.Match("10.0.0.1", 0)
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []uint64{1})
+		})
+
+		Convey("parts of names should be found", func() {
+			ids, _ := s.Match("example", 0)
+			So(ids, ShouldResemble, []uint64{2})
+		})
+
+		Convey("AND and OR should be supported", func() {
+			ids, _ := s.Match("connection AND refused", 0)
+			So(ids, ShouldResemble, []uint64{2, 4})
+
+			ids, _ = s.Match("web-1 OR db-1", 0)
+			So(ids, ShouldResemble, []uint64{1, 3})
+		})
+
+		Convey("phrases should match words in order", func() {
+			ids, _ := s.Match(`"connection refused"`, 0)
+			So(ids, ShouldResemble, []uint64{2})
+		})
+
+		Convey("matches should be fetched with their context", func() {
+			records, err := s.Context(2, 1, 1)
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 3)
+			So(records[0].ID, ShouldEqual, 1)
+		})
+
+		Convey("negative context sizes should be taken as none", func() {
+			records, err := s.Context(2, -5, -1)
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].ID, ShouldEqual, 2)
+		})
+
+		Convey("invalid queries should be reported", func() {
+			_, err := s.Match(`"connection`, 0)
+			So(err, ShouldHaveSameTypeAs, QueryError(""))
+		})
+	})
+}
@@ -0,0 +1,279 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const segmentExt = ".seg"
+const indexExt = ".idx"
+
+// entry : index entry pointing to a record on a segment file
+type entry struct {
+	ID      uint64 `json:"i"`
+	Time    int64  `json:"t"`
+	Offset  int64  `json:"o"`
+	Length  int    `json:"n"`
+	Level   string `json:"l,omitempty"`
+	User    string `json:"u,omitempty"`
+	Subject string `json:"s"`
+}
+
+// segment : an append-only file of JSON encoded records, one per line,
+// and the index of subjects and times kept next to it
+type segment struct {
+	first   uint64
+	path    string
+	data    *os.File
+	index   *os.File
+	entries []entry
+	size    int64
+	terms   terms
+}
+
+func segmentName(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d", first))
+}
+
+func createSegment(dir string, first uint64, fullText bool) (*segment, error) {
+	s := segment{first: first, path: segmentName(dir, first)}
+	if fullText {
+		s.terms = make(terms)
+	}
+	return &s, s.openFiles()
+}
+
+// openSegment : opens an existing segment, rebuilding its index when it
+// is missing or doesn't cover the whole segment. The full-text index is
+// only kept in memory, so it is built again from the records.
+func openSegment(path string, fullText bool) (*segment, error) {
+	base := strings.TrimSuffix(path, segmentExt)
+	first, err := strconv.ParseUint(filepath.Base(base), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment name '%s'", path)
+	}
+
+	s := segment{first: first, path: base}
+	if err := s.openFiles(); err != nil {
+		return nil, err
+	}
+
+	fi, err := s.data.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.size = fi.Size()
+
+	if err := s.loadIndex(); err != nil || !s.indexed() {
+		if err := s.rebuildIndex(); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	if fullText {
+		if err := s.buildTerms(); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+func (s *segment) buildTerms() error {
+	s.terms = make(terms)
+	for i := range s.entries {
+		r, err := s.read(&s.entries[i])
+		if err != nil {
+			return err
+		}
+		s.terms.add(i, recordText(r))
+	}
+	return nil
+}
+
+func (s *segment) openFiles() error {
+	var err error
+
+	s.data, err = os.OpenFile(s.path+segmentExt, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	s.index, err = os.OpenFile(s.path+indexExt, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		_ = s.data.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *segment) loadIndex() error {
+	if _, err := s.index.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.entries = nil
+	sc := bufio.NewScanner(s.index)
+	for sc.Scan() {
+		var e entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return err
+		}
+		s.entries = append(s.entries, e)
+	}
+
+	return sc.Err()
+}
+
+// indexed : checks the index ends exactly where the segment does
+func (s *segment) indexed() bool {
+	if len(s.entries) == 0 {
+		return s.size == 0
+	}
+	last := s.entries[len(s.entries)-1]
+	return last.Offset+int64(last.Length)+1 == s.size
+}
+
+func (s *segment) rebuildIndex() error {
+	if err := s.index.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.entries = nil
+	var offset int64
+
+	r := bufio.NewReader(s.data)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// drop any partially written record
+			if len(line) > 0 {
+				if err := s.data.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err == nil {
+			if err := s.addEntry(newEntry(&rec, offset, len(line)-1)); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	s.size = offset
+
+	return nil
+}
+
+func newEntry(r *Record, offset int64, length int) entry {
+	return entry{
+		ID:      r.ID,
+		Time:    r.Time.UnixNano(),
+		Offset:  offset,
+		Length:  length,
+		Level:   r.Level,
+		User:    r.User,
+		Subject: r.Subject,
+	}
+}
+
+func (s *segment) addEntry(e entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.index.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *segment) append(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.data.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	e := newEntry(r, s.size, len(data))
+	s.size += int64(len(data)) + 1
+
+	if err := s.addEntry(e); err != nil {
+		return err
+	}
+
+	if s.terms != nil {
+		s.terms.add(len(s.entries)-1, recordText(r))
+	}
+
+	return nil
+}
+
+func (s *segment) read(e *entry) (*Record, error) {
+	data := make([]byte, e.Length)
+	if _, err := s.data.ReadAt(data, e.Offset); err != nil {
+		return nil, err
+	}
+
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (s *segment) lastTime() time.Time {
+	if len(s.entries) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, s.entries[len(s.entries)-1].Time)
+}
+
+func (s *segment) firstTime() time.Time {
+	if len(s.entries) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, s.entries[0].Time)
+}
+
+func (s *segment) close() {
+	_ = s.data.Close()
+	_ = s.index.Close()
+}
+
+func (s *segment) remove() error {
+	s.close()
+	if err := os.Remove(s.path + segmentExt); err != nil {
+		return err
+	}
+	return os.Remove(s.path + indexExt)
+}
@@ -0,0 +1,433 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+)
+
+const defaultRetention = time.Hour * 24 * 7
+const defaultSegmentSize = 64 * 1024 * 1024
+const defaultLimit = 100
+const maxLimit = 1000
+const pruneInterval = time.Minute * 10
+
+// ErrNotFound : returned when a record is not on the store
+var ErrNotFound = errors.New("Record not found")
+
+// Record : an obfuscated log record as persisted on the store
+type Record struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Message string    `json:"message,omitempty"`
+	Body    string    `json:"body,omitempty"`
+	Level   string    `json:"level"`
+	User    string    `json:"user"`
+}
+
+// Config : store settings
+type Config struct {
+	Dir         string
+	Retention   time.Duration
+	SegmentSize int64
+	// FullText : keeps an inverted index of the tokens on record bodies
+	FullText bool
+}
+
+// ConfigFromEnv : reads the store settings from ERNEST_STORE_DIR,
+// ERNEST_STORE_RETENTION, ERNEST_STORE_SEGMENT_SIZE and
+// ERNEST_STORE_INDEX. The store is disabled when no directory is set.
+func ConfigFromEnv() Config {
+	c := Config{Dir: os.Getenv("ERNEST_STORE_DIR")}
+	c.FullText, _ = strconv.ParseBool(os.Getenv("ERNEST_STORE_INDEX"))
+
+	if r, err := time.ParseDuration(os.Getenv("ERNEST_STORE_RETENTION")); err == nil {
+		c.Retention = r
+	}
+	if s, err := strconv.ParseInt(os.Getenv("ERNEST_STORE_SEGMENT_SIZE"), 10, 64); err == nil {
+		c.SegmentSize = s
+	}
+
+	return c
+}
+
+// Store : append-only, indexed store of log records
+type Store struct {
+	config   Config
+	segments []*segment
+	lastID   uint64
+	done     chan struct{}
+	mu       sync.RWMutex
+}
+
+// Open : opens the store on the configured directory, loading any
+// existing segments
+func Open(c Config) (*Store, error) {
+	if c.Dir == "" {
+		return nil, errors.New("No store directory specified")
+	}
+	if c.Retention <= 0 {
+		c.Retention = defaultRetention
+	}
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = defaultSegmentSize
+	}
+
+	if err := os.MkdirAll(c.Dir, 0750); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.Dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	s := Store{config: c, done: make(chan struct{})}
+	for _, p := range paths {
+		seg, err := openSegment(p, c.FullText)
+		if err != nil {
+			s.closeSegments()
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	if n := len(s.segments); n > 0 {
+		last := s.segments[n-1]
+		s.lastID = last.first - 1
+		if len(last.entries) > 0 {
+			s.lastID = last.entries[len(last.entries)-1].ID
+		}
+	}
+
+	s.prune()
+	go s.pruneLoop()
+
+	return &s, nil
+}
+
+// Append : persists a record, setting its ID and, if missing, its time
+func (s *Store) Append(r Record) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active, err := s.active()
+	if err != nil {
+		return r, err
+	}
+
+	s.lastID++
+	r.ID = s.lastID
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	return r, active.append(&r)
+}
+
+// active : returns the segment to write to, starting a new one when the
+// current one is full
+func (s *Store) active() (*segment, error) {
+	n := len(s.segments)
+	if n > 0 && s.segments[n-1].size < s.config.SegmentSize {
+		return s.segments[n-1], nil
+	}
+
+	seg, err := createSegment(s.config.Dir, s.lastID+1, s.config.FullText)
+	if err != nil {
+		return nil, err
+	}
+	s.segments = append(s.segments, seg)
+
+	return seg, nil
+}
+
+// Get : returns the record with the given ID
+func (s *Store) Get(id uint64) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seg := s.segmentFor(id)
+	if seg == nil {
+		return nil, ErrNotFound
+	}
+
+	i := sort.Search(len(seg.entries), func(i int) bool {
+		return seg.entries[i].ID >= id
+	})
+	if i == len(seg.entries) || seg.entries[i].ID != id {
+		return nil, ErrNotFound
+	}
+
+	return seg.read(&seg.entries[i])
+}
+
+func (s *Store) segmentFor(id uint64) *segment {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		if s.segments[i].first <= id {
+			return s.segments[i]
+		}
+	}
+	return nil
+}
+
+// Search : returns, oldest first, the most recent records matching the
+// query
+func (s *Store) Search(q *Query) ([]Record, error) {
+	var match matchQuery
+	if q.Match != "" {
+		if !s.config.FullText {
+			return nil, ErrNoIndex
+		}
+		var err error
+		if match, err = parseMatch(q.Match); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	limit := q.limit()
+	records := make([]Record, 0)
+
+	for i := len(s.segments) - 1; i >= 0 && len(records) < limit; i-- {
+		seg := s.segments[i]
+		if len(seg.entries) == 0 {
+			continue
+		}
+		if !q.From.IsZero() && seg.lastTime().Before(q.From) {
+			break
+		}
+		if !q.To.IsZero() && seg.firstTime().After(q.To) {
+			continue
+		}
+
+		for _, j := range positions(seg, match) {
+			if len(records) == limit {
+				break
+			}
+
+			e := &seg.entries[j]
+			if !q.matchEntry(e) {
+				continue
+			}
+
+			r, err := seg.read(e)
+			if err != nil {
+				return nil, err
+			}
+			if !q.matchRecord(r) {
+				continue
+			}
+			if match != nil && !match.matchText(recordText(r)) {
+				continue
+			}
+			records = append(records, *r)
+		}
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
+
+// positions : entries of the segment to look at, newest first. Only the
+// candidates found on the full-text index when there's a match query.
+func positions(seg *segment, match matchQuery) []int {
+	if match != nil {
+		return match.candidates(seg.terms)
+	}
+
+	p := make([]int, len(seg.entries))
+	for i := range p {
+		p[i] = len(p) - 1 - i
+	}
+	return p
+}
+
+// Match : returns, oldest first, the IDs of the most recent records
+// matching the full-text query
+func (s *Store) Match(expr string, limit int) ([]uint64, error) {
+	records, err := s.Search(&Query{Match: expr, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+
+	return ids, nil
+}
+
+// Context : returns the record with the given ID surrounded by up to
+// before and after of its neighbours. Negative counts are taken as 0.
+func (s *Store) Context(id uint64, before, after int) ([]Record, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+
+	if before < 0 {
+		before = 0
+	}
+	if after < 0 {
+		after = 0
+	}
+	if before > maxLimit {
+		before = maxLimit
+	}
+	if after > maxLimit {
+		after = maxLimit
+	}
+
+	var from uint64 = 1
+	if uint64(before) < id {
+		from = id - uint64(before)
+	}
+
+	records := make([]Record, 0, before+after+1)
+	for i := from; i <= id+uint64(after); i++ {
+		r, err := s.Get(i)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+
+	return records, nil
+}
+
+// prune : removes the segments older than the retention period. The
+// segment being written is always kept.
+func (s *Store) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := time.Now().Add(-s.config.Retention)
+	for len(s.segments) > 1 && s.segments[0].lastTime().Before(limit) {
+		if err := s.segments[0].remove(); err != nil {
+			diag.Error(err.Error())
+		}
+		s.segments = s.segments[1:]
+	}
+}
+
+func (s *Store) pruneLoop() {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.prune()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close : stops the store, closing all its files
+func (s *Store) Close() {
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeSegments()
+}
+
+func (s *Store) closeSegments() {
+	for _, seg := range s.segments {
+		seg.close()
+	}
+	s.segments = nil
+}
+
+// Query : filters for searching the store
+type Query struct {
+	Subject string    `json:"subject,omitempty"`
+	Level   string    `json:"level,omitempty"`
+	User    string    `json:"user,omitempty"`
+	From    time.Time `json:"from,omitempty"`
+	To      time.Time `json:"to,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	// Match : full-text query run against the inverted index
+	Match string `json:"match,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+func (q *Query) limit() int {
+	if q.Limit <= 0 {
+		return defaultLimit
+	}
+	if q.Limit > maxLimit {
+		return maxLimit
+	}
+	return q.Limit
+}
+
+func (q *Query) matchEntry(e *entry) bool {
+	if !q.From.IsZero() && e.Time < q.From.UnixNano() {
+		return false
+	}
+	if !q.To.IsZero() && e.Time > q.To.UnixNano() {
+		return false
+	}
+	if q.Level != "" && q.Level != e.Level {
+		return false
+	}
+	if q.User != "" && q.User != e.User {
+		return false
+	}
+	if q.Subject != "" && !MatchSubject(q.Subject, e.Subject) {
+		return false
+	}
+	return true
+}
+
+func (q *Query) matchRecord(r *Record) bool {
+	if q.Text == "" {
+		return true
+	}
+	return strings.Contains(r.Message, q.Text) || strings.Contains(r.Body, q.Text)
+}
+
+// MatchSubject : matches a subject against a nats style pattern, where
+// '*' matches a single token and '>' all remaining tokens
+func MatchSubject(pattern, subject string) bool {
+	p := strings.Split(pattern, ".")
+	t := strings.Split(subject, ".")
+
+	for i, token := range p {
+		if token == ">" {
+			return len(t) > i
+		}
+		if i >= len(t) {
+			return false
+		}
+		if token != "*" && token != t[i] {
+			return false
+		}
+	}
+
+	return len(p) == len(t)
+}
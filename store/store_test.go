@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStore(t *testing.T) {
+	Convey("Given a store with some records", t, func() {
+		dir, _ := ioutil.TempDir("", "store")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		s, err := Open(Config{Dir: dir, SegmentSize: 200})
+		So(err, ShouldBeNil)
+
+		start := time.Now().UTC()
+		for _, r := range []Record{
+			{Subject: "instance.create", Body: `{"name":"web-1"}`, Level: "debug", User: "system"},
+			{Subject: "instance.create.error", Body: `{"name":"web-2"}`, Level: "error", User: "system"},
+			{Subject: "network.create", Body: `{"name":"net-1"}`, Level: "debug", User: "system"},
+			{Subject: "build.submitted", Message: "build for web", Level: "info", User: "alice"},
+		} {
+			_, err := s.Append(r)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("records should be split into segments", func() {
+			So(len(s.segments), ShouldBeGreaterThan, 1)
+		})
+
+		Convey("records should be found by subject, level and user", func() {
+			records, err := s.Search(&Query{Subject: "instance.>"})
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 2)
+			So(records[0].ID, ShouldEqual, 1)
+
+			records, _ = s.Search(&Query{Level: "error"})
+			So(len(records), ShouldEqual, 1)
+
+			records, _ = s.Search(&Query{User: "alice"})
+			So(len(records), ShouldEqual, 1)
+		})
+
+		Convey("records should be found by text and time", func() {
+			records, _ := s.Search(&Query{Text: "web"})
+			So(len(records), ShouldEqual, 3)
+
+			records, _ = s.Search(&Query{From: start.Add(time.Hour)})
+			So(len(records), ShouldEqual, 0)
+		})
+
+		Convey("records should be fetched by ID", func() {
+			r, err := s.Get(3)
+			So(err, ShouldBeNil)
+			So(r.Subject, ShouldEqual, "network.create")
+
+			_, err = s.Get(42)
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("a reopened store should rebuild missing indexes and continue IDs", func() {
+			s.Close()
+			idx, _ := filepath.Glob(filepath.Join(dir, "*"+indexExt))
+			So(os.Remove(idx[0]), ShouldBeNil)
+
+			s, err = Open(Config{Dir: dir, SegmentSize: 200})
+			So(err, ShouldBeNil)
+
+			records, _ := s.Search(&Query{})
+			So(len(records), ShouldEqual, 4)
+
+			r, _ := s.Append(Record{Subject: "x"})
+			So(r.ID, ShouldEqual, 5)
+		})
+
+		Reset(func() {
+			s.Close()
+		})
+	})
+}
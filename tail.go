@@ -0,0 +1,35 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+var tailListener = func(m *nats.Msg) {
+	var f Filter
+	if len(m.Data) > 0 {
+		if err := json.Unmarshal(m.Data, &f); err != nil {
+			diag.Error(err.Error())
+			if err := nc.Publish(m.Reply, []byte(`{"error":"Invalid tail request"}`)); err != nil {
+				diag.Error(err.Error())
+			}
+			return
+		}
+	}
+
+	body, err := json.Marshal(buffer.Query(&f))
+	if err != nil {
+		diag.Error(err.Error())
+		body = []byte(`{"error":"Unexpected error ocurred"}`)
+	}
+
+	if err := nc.Publish(m.Reply, body); err != nil {
+		diag.Error(err.Error())
+	}
+}
@@ -0,0 +1,309 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ads "github.com/ernestio/logger/adapters"
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+// apiTokenPrefix : tells API tokens apart from jwts
+const apiTokenPrefix = "elt_"
+
+// errPublicReply : refuses token requests answered on a subject other
+// than an inbox, as anything subscribed to it, adapters included, would
+// see the reply
+var errPublicReply = errors.New("Token requests must be replied on an _INBOX subject")
+
+// ErrTokenNotFound : returned when revoking an unknown API token
+var ErrTokenNotFound = errors.New("API token not found")
+
+// APIToken : long-lived token for machine consumers of the live logs.
+// Only a hash of its secret is kept.
+type APIToken struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Admin   bool                `json:"admin,omitempty"`
+	Scope   map[string][]string `json:"scope,omitempty"`
+	Filter  *Filter             `json:"filter,omitempty"`
+	Created time.Time           `json:"created"`
+	Hash    string              `json:"hash,omitempty"`
+	// Token : the token itself, only returned once on creation
+	Token string `json:"token,omitempty"`
+}
+
+// TokenStore : API tokens persisted on the logger's config directory
+type TokenStore struct {
+	path   string
+	tokens map[string]*APIToken
+	mu     sync.RWMutex
+}
+
+// OpenTokenStore : loads the API tokens kept on the given file, if any
+func OpenTokenStore(path string) (*TokenStore, error) {
+	s := TokenStore{path: path, tokens: make(map[string]*APIToken)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.New("API tokens file '" + path + "' is corrupted")
+	}
+	for _, t := range tokens {
+		s.tokens[t.ID] = t
+	}
+
+	return &s, nil
+}
+
+// Create : issues a new token, returned with its secret
+func (s *TokenStore) Create(t APIToken) (*APIToken, error) {
+	if t.Name == "" {
+		return nil, errors.New("API tokens need a name")
+	}
+	if !t.Admin && len(t.Scope) == 0 {
+		return nil, errors.New("API tokens must be admin or scoped")
+	}
+	for claim := range t.Scope {
+		if _, ok := scopeRules[claim]; !ok {
+			return nil, errors.New("Unknown scope '" + claim + "'")
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	t.ID = id
+	t.Created = time.Now().UTC()
+	t.Hash = hashSecret(secret)
+	t.Token = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[t.ID] = &t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.ID)
+		return nil, err
+	}
+
+	created := t
+	created.Hash = ""
+	created.Token = apiTokenPrefix + id + "_" + secret
+
+	return &created, nil
+}
+
+// List : returns the active tokens, without their hashes, oldest first
+func (s *TokenStore) List() []APIToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		l := *t
+		l.Hash = ""
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	return list
+}
+
+// Revoke : deletes a token. See revokeToken to also close the
+// connections opened with it.
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = t
+		return err
+	}
+
+	return nil
+}
+
+// Identify : returns the identity of a valid API token
+func (s *TokenStore) Identify(token string) (*Identity, error) {
+	parts := strings.SplitN(strings.TrimPrefix(token, apiTokenPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, errUnauthorized
+	}
+
+	s.mu.RLock()
+	t, ok := s.tokens[parts[0]]
+	s.mu.RUnlock()
+
+	if !ok || subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(t.Hash)) != 1 {
+		return nil, errUnauthorized
+	}
+
+	return &Identity{
+		Username: "token:" + t.Name,
+		Admin:    t.Admin,
+		Scope:    t.Scope,
+		Filter:   t.Filter,
+		TokenID:  t.ID,
+	}, nil
+}
+
+// save : writes the tokens to a temporary file first, so a failure
+// never leaves a truncated file behind. The caller must hold the lock.
+func (s *TokenStore) save() error {
+	tokens := make([]*APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func setupTokens() {
+	file := ".tokens"
+	if path := os.Getenv("ERNEST_LOG_CONFIG"); path != "" {
+		file = path + file
+	}
+
+	s, err := OpenTokenStore(file)
+	if err != nil {
+		diag.Fatal(err)
+	}
+	apitokens = s
+}
+
+// checkTokenReply : makes sure a token request is answered on a subject
+// never logged nor broadcast
+func checkTokenReply(m *nats.Msg) error {
+	if !ads.Private(m.Reply) {
+		return errPublicReply
+	}
+	return nil
+}
+
+var createTokenListener = func(m *nats.Msg) {
+	var t APIToken
+
+	if err := checkTokenReply(m); err != nil {
+		reply(m, nil, err)
+		return
+	}
+
+	data, err := control(m, tokensTarget)
+	if err != nil {
+		reply(m, nil, err)
+		return
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		invalidRequest(m, err)
+		return
+	}
+
+	created, err := apitokens.Create(t)
+	if err == nil {
+		diag.Info("API token '" + created.Name + "' created with id " + created.ID)
+	}
+	reply(m, created, err)
+}
+
+var listTokensListener = func(m *nats.Msg) {
+	if err := checkTokenReply(m); err != nil {
+		reply(m, nil, err)
+		return
+	}
+	if _, err := control(m, tokensTarget); err != nil {
+		reply(m, nil, err)
+		return
+	}
+	reply(m, apitokens.List(), nil)
+}
+
+// revokeToken : deletes an API token and evicts the viewers still
+// following the logs with it
+func revokeToken(id string) error {
+	if err := apitokens.Revoke(id); err != nil {
+		return err
+	}
+
+	n := viewers.EvictToken(id)
+	diag.Info("API token " + id + " revoked, " + strconv.Itoa(n) + " connections closed")
+
+	return nil
+}
+
+var revokeTokenListener = func(m *nats.Msg) {
+	var t APIToken
+
+	data, err := control(m, tokensTarget)
+	if err != nil {
+		reply(m, nil, err)
+		return
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		invalidRequest(m, err)
+		return
+	}
+
+	err = revokeToken(t.ID)
+	reply(m, map[string]string{"id": t.ID, "status": "revoked"}, err)
+}
@@ -0,0 +1,88 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenStore(t *testing.T) {
+	Convey("Given a token store", t, func() {
+		dir, _ := ioutil.TempDir("", "tokens")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, ".tokens")
+
+		s, err := OpenTokenStore(path)
+		So(err, ShouldBeNil)
+
+		Convey("a scoped token should be created with a filter", func() {
+			created, err := s.Create(APIToken{
+				Name:   "siem",
+				Scope:  map[string][]string{"groups": {"g1"}},
+				Filter: &Filter{Subjects: []string{"build.>"}},
+			})
+			So(err, ShouldBeNil)
+			So(created.Token, ShouldStartWith, apiTokenPrefix)
+
+			Convey("only its hash should be stored", func() {
+				data, _ := ioutil.ReadFile(path)
+				So(strings.Contains(string(data), strings.Split(created.Token, "_")[2]), ShouldBeFalse)
+				So(s.List()[0].Hash, ShouldEqual, "")
+			})
+
+			Convey("it should identify its owner with its scope and filter", func() {
+				id, err := s.Identify(created.Token)
+				So(err, ShouldBeNil)
+				So(id.Username, ShouldEqual, "token:siem")
+				So(id.Allows(&LogMessage{Subject: "build.create", Body: `{"group_id":"g1"}`}), ShouldBeTrue)
+				So(id.Allows(&LogMessage{Subject: "instance.create", Body: `{"group_id":"g1"}`}), ShouldBeFalse)
+				So(id.Allows(&LogMessage{Subject: "build.create", Body: `{"group_id":"g2"}`}), ShouldBeFalse)
+			})
+
+			Convey("a wrong secret should not be accepted", func() {
+				_, err := s.Identify(created.Token[:len(created.Token)-1] + "x")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("it should be kept after reopening the store", func() {
+				reopened, err := OpenTokenStore(path)
+				So(err, ShouldBeNil)
+				_, err = reopened.Identify(created.Token)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("once revoked it should not be accepted", func() {
+				So(s.Revoke(created.ID), ShouldBeNil)
+				_, err := s.Identify(created.Token)
+				So(err, ShouldNotBeNil)
+				So(s.Revoke(created.ID), ShouldEqual, ErrTokenNotFound)
+			})
+		})
+
+		Convey("tokens without name, or without admin or scope, should be refused", func() {
+			_, err := s.Create(APIToken{Admin: true})
+			So(err, ShouldNotBeNil)
+			_, err = s.Create(APIToken{Name: "bot"})
+			So(err, ShouldNotBeNil)
+			_, err = s.Create(APIToken{Name: "bot", Scope: map[string][]string{"unknown": {"x"}}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given token requests", t, func() {
+		Convey("they should only be answered on an inbox", func() {
+			So(checkTokenReply(&nats.Msg{Subject: "logger.token.create", Reply: "_INBOX.yUcTJTo9zTx4Da5hJwiUde"}), ShouldBeNil)
+			So(checkTokenReply(&nats.Msg{Subject: "logger.token.create", Reply: "audit.replies"}), ShouldEqual, errPublicReply)
+			So(checkTokenReply(&nats.Msg{Subject: "logger.token.create"}), ShouldEqual, errPublicReply)
+		})
+	})
+}
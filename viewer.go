@@ -0,0 +1,229 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+)
+
+// viewerHandler : serves the built-in log viewer page
+func viewerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'; connect-src 'self' ws: wss:")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write([]byte(viewerPage))
+}
+
+// viewerPage : single page viewer following /logs. Kept inline so the
+// binary stays self contained.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Ernest logs</title>
+<style>
+  body { margin: 0; font: 13px monospace; background: #1d1f21; color: #c5c8c6; }
+  header { position: sticky; top: 0; display: flex; gap: 8px; align-items: center; padding: 8px; background: #282a2e; }
+  header input, header select, header button { font: inherit; }
+  #login { padding: 40px; }
+  #status { margin-left: auto; }
+  #records { padding: 8px; }
+  .record { white-space: pre-wrap; word-break: break-all; border-bottom: 1px solid #282a2e; cursor: pointer; }
+  .record.historical { opacity: 0.6; }
+  .record .subject { color: #81a2be; }
+  .record .level-error { color: #cc6666; }
+  .record pre { margin: 4px 0 4px 16px; color: #b5bd68; }
+  .notice { color: #f0c674; }
+  .hidden { display: none; }
+</style>
+</head>
+<body>
+<div id="login">
+  <form id="login-form">
+    <input id="token" type="password" placeholder="Token" size="60" required>
+    <button type="submit">Connect</button>
+  </form>
+</div>
+<div id="viewer" class="hidden">
+  <header>
+    <input id="subjects" placeholder="Subjects, e.g. instance.&gt;, network.*" size="40">
+    <select id="level">
+      <option value="">All levels</option>
+      <option>debug</option>
+      <option>info</option>
+      <option>warn</option>
+      <option>error</option>
+    </select>
+    <button id="apply">Apply</button>
+    <button id="pause">Pause</button>
+    <button id="clear">Clear</button>
+    <button id="logout">Logout</button>
+    <span id="status"></span>
+  </header>
+  <div id="records"></div>
+</div>
+<script>
+(function () {
+  var maxRecords = 5000;
+  var socket = null;
+  var lastEventID = null;
+  var paused = false;
+  var pending = [];
+  var dropped = 0;
+  var records = document.getElementById('records');
+
+  function $(id) { return document.getElementById(id); }
+
+  function status(text) { $('status').textContent = text; }
+
+  function filter() {
+    var f = {};
+    var subjects = $('subjects').value.split(',').map(function (s) { return s.trim(); }).filter(Boolean);
+    if (subjects.length) { f.subjects = subjects; }
+    if ($('level').value) { f.levels = [$('level').value]; }
+    return f;
+  }
+
+  function pretty(body) {
+    try { return JSON.stringify(JSON.parse(body), null, 2); } catch (e) { return body; }
+  }
+
+  function atBottom() {
+    return window.innerHeight + window.scrollY >= document.body.offsetHeight - 20;
+  }
+
+  function render(r) {
+    var follow = atBottom();
+    var el = document.createElement('div');
+    el.className = 'record' + (r.historical ? ' historical' : '');
+
+    var subject = document.createElement('span');
+    subject.className = 'subject';
+    subject.textContent = r.subject;
+
+    var level = document.createElement('span');
+    level.className = 'level-' + r.level;
+    level.textContent = '[' + r.level + '] ';
+
+    var text = r.body || r.message || '';
+    var summary = document.createTextNode(' ' + (r.time || '') + ' ' + text.slice(0, 200));
+
+    el.appendChild(level);
+    el.appendChild(subject);
+    el.appendChild(summary);
+    el.addEventListener('click', function () {
+      var open = el.querySelector('pre');
+      if (open) { el.removeChild(open); return; }
+      var pre = document.createElement('pre');
+      pre.textContent = pretty(text);
+      el.appendChild(pre);
+    });
+
+    records.appendChild(el);
+    while (records.childNodes.length > maxRecords) { records.removeChild(records.firstChild); }
+    if (follow) { window.scrollTo(0, document.body.scrollHeight); }
+  }
+
+  function notice(text) {
+    var el = document.createElement('div');
+    el.className = 'notice';
+    el.textContent = text;
+    records.appendChild(el);
+  }
+
+  function receive(data) {
+    var msg = JSON.parse(data);
+    if (msg.status) {
+      if (msg.status === 'gap') { notice('Some events were missed while disconnected'); }
+      if (msg.status === 'unauthorized') { logout(); }
+      if (msg.status === 'too many connections') { notice('Too many open connections for this user'); }
+      return;
+    }
+    if (msg.event_id) { lastEventID = msg.event_id; }
+    if (paused) {
+      // only the last maxRecords could be shown anyway
+      pending.push(msg);
+      if (pending.length > maxRecords) { pending.shift(); dropped++; }
+      return;
+    }
+    render(msg);
+  }
+
+  function connect() {
+    var token = sessionStorage.getItem('ernest-logs-token');
+    if (!token) { return; }
+
+    $('login').classList.add('hidden');
+    $('viewer').classList.remove('hidden');
+
+    var url = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/logs';
+    socket = new WebSocket(url);
+    status('connecting');
+
+    socket.onopen = function () {
+      var auth = { token: token, filter: filter() };
+      if (lastEventID) { auth.event_id = lastEventID; } else { auth.backlog = 200; }
+      socket.send(JSON.stringify(auth));
+      status('live');
+    };
+    socket.onmessage = function (e) { receive(e.data); };
+    socket.onclose = function (e) {
+      socket = null;
+      if (!sessionStorage.getItem('ernest-logs-token')) { return; }
+      status('disconnected' + (e.reason ? ': ' + e.reason : '') + ', retrying');
+      setTimeout(connect, 3000);
+    };
+  }
+
+  function logout() {
+    sessionStorage.removeItem('ernest-logs-token');
+    if (socket) { socket.close(); }
+    $('viewer').classList.add('hidden');
+    $('login').classList.remove('hidden');
+  }
+
+  $('login-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    sessionStorage.setItem('ernest-logs-token', $('token').value);
+    $('token').value = '';
+    connect();
+  });
+
+  $('apply').addEventListener('click', function () {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+      socket.send(JSON.stringify({ filter: filter() }));
+    }
+  });
+
+  $('pause').addEventListener('click', function () {
+    paused = !paused;
+    $('pause').textContent = paused ? 'Resume (' + pending.length + ')' : 'Pause';
+    if (!paused) {
+      if (dropped) { notice(dropped + ' events dropped while paused'); }
+      pending.forEach(render);
+      pending = [];
+      dropped = 0;
+    }
+  });
+
+  $('clear').addEventListener('click', function () { records.innerHTML = ''; });
+  $('logout').addEventListener('click', logout);
+
+  connect();
+})();
+</script>
+</body>
+</html>
+`
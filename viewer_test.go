@@ -0,0 +1,31 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestViewerHandler(t *testing.T) {
+	Convey("Given the viewer page handler", t, func() {
+		Convey("it should serve the page on the root path", func() {
+			w := httptest.NewRecorder()
+			viewerHandler(w, httptest.NewRequest("GET", "/", nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+			So(w.Body.String(), ShouldContainSubstring, "/logs")
+		})
+
+		Convey("it should not answer for unknown paths", func() {
+			w := httptest.NewRecorder()
+			viewerHandler(w, httptest.NewRequest("GET", "/unknown", nil))
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
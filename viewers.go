@@ -0,0 +1,236 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ernestio/logger/diag"
+	"github.com/nats-io/go-nats"
+)
+
+// defaultViewerQueue : events queued for a viewer before it is
+// considered too slow and evicted
+const defaultViewerQueue = 256
+
+// slowConsumer : reason given to evicted viewers
+const slowConsumer = "Client too slow, it fell too far behind the live logs"
+
+// tokenRevoked : reason given to viewers whose API token was revoked
+const tokenRevoked = "The token of this connection was revoked"
+
+// ErrTooManyConnections : returned when a user reached its connection limit
+var ErrTooManyConnections = errors.New("Too many connections")
+
+// ErrTooManyStreams : returned when the logger reached its stream limit
+var ErrTooManyStreams = errors.New("Too many streams")
+
+// Viewer : a single connection following the live logs, with its own
+// queue of pending events
+type Viewer struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	TokenID    string    `json:"token_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Transport  string    `json:"transport"`
+	Connected  time.Time `json:"connected"`
+	Pending    int       `json:"pending"`
+	events     chan []byte
+	// reason : why the hub evicted the viewer, set before closing events
+	// so it is safe to read once they are drained
+	reason string
+}
+
+// Hub : hands every published event to all connected viewers
+type Hub struct {
+	viewers map[string]*Viewer
+	perUser int
+	queue   int
+	max     int
+	lastID  uint64
+	mu      sync.RWMutex
+}
+
+// NewHub : Hub constructor, perUser limits the connections of a single
+// user, 0 meaning no limit, and queue the events pending for a viewer
+func NewHub(perUser, queue int) *Hub {
+	if queue <= 0 {
+		queue = defaultViewerQueue
+	}
+
+	return &Hub{
+		viewers: make(map[string]*Viewer),
+		perUser: perUser,
+		queue:   queue,
+	}
+}
+
+// newHubFromEnv : limits the connections per user with
+// ERNEST_MAX_VIEWERS_PER_USER, the pending events per connection with
+// ERNEST_VIEWER_QUEUE and the connections at once with ERNEST_MAX_STREAMS
+func newHubFromEnv() *Hub {
+	perUser, _ := strconv.Atoi(os.Getenv("ERNEST_MAX_VIEWERS_PER_USER"))
+	queue, _ := strconv.Atoi(os.Getenv("ERNEST_VIEWER_QUEUE"))
+
+	h := NewHub(perUser, queue)
+	h.max, _ = strconv.Atoi(os.Getenv("ERNEST_MAX_STREAMS"))
+
+	return h
+}
+
+// Join : registers a new viewer for the given user, and the id of the API
+// token it authenticated with, if any
+func (h *Hub) Join(username, tokenID, remoteAddr, transport string) (*Viewer, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.max > 0 && len(h.viewers) >= h.max {
+		return nil, ErrTooManyStreams
+	}
+	if h.perUser > 0 && h.count(username) >= h.perUser {
+		return nil, ErrTooManyConnections
+	}
+
+	h.lastID++
+	v := Viewer{
+		ID:         strconv.FormatUint(h.lastID, 10),
+		Username:   username,
+		TokenID:    tokenID,
+		RemoteAddr: remoteAddr,
+		Transport:  transport,
+		Connected:  time.Now().UTC(),
+		events:     make(chan []byte, h.queue),
+	}
+	h.viewers[v.ID] = &v
+
+	return &v, nil
+}
+
+// Leave : removes a viewer, closing its queue
+func (h *Hub) Leave(v *Viewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.viewers[v.ID]; !ok {
+		return
+	}
+	delete(h.viewers, v.ID)
+	close(v.events)
+}
+
+// EvictToken : evicts the viewers authenticated with the given API token,
+// returning how many were
+func (h *Hub) EvictToken(tokenID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var n int
+	for id, v := range h.viewers {
+		if v.TokenID != tokenID {
+			continue
+		}
+		v.reason = tokenRevoked
+		delete(h.viewers, id)
+		close(v.events)
+		n++
+	}
+
+	return n
+}
+
+// Full : checks if the hub reached its limit of viewers
+func (h *Hub) Full() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.max > 0 && len(h.viewers) >= h.max
+}
+
+// Len : returns the number of connected viewers
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.viewers)
+}
+
+func (h *Hub) count(username string) int {
+	var n int
+	for _, v := range h.viewers {
+		if v.Username == username {
+			n++
+		}
+	}
+	return n
+}
+
+// Publish : queues an event for every viewer. Viewers whose queue is full
+// are evicted, so a slow client never holds back the others.
+func (h *Hub) Publish(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, v := range h.viewers {
+		select {
+		case v.events <- data:
+		default:
+			diag.Warn("Evicting slow viewer " + v.Username + " from " + v.RemoteAddr)
+			v.reason = slowConsumer
+			delete(h.viewers, id)
+			close(v.events)
+		}
+	}
+}
+
+// List : returns the connected viewers, oldest first
+func (h *Hub) List() []Viewer {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := make([]Viewer, 0, len(h.viewers))
+	for _, v := range h.viewers {
+		l := *v
+		l.Pending = len(v.events)
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Connected.Before(list[j].Connected)
+	})
+
+	return list
+}
+
+var viewersListener = func(m *nats.Msg) {
+	body, err := json.Marshal(viewers.List())
+	if err != nil {
+		diag.Error(err.Error())
+		body = []byte(`{"error":"Unexpected error ocurred"}`)
+	}
+
+	if err := nc.Publish(m.Reply, body); err != nil {
+		diag.Error(err.Error())
+	}
+}
+
+func viewersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, status, err := authorize(w, r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, viewers.List())
+}
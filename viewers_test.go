@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHub(t *testing.T) {
+	Convey("Given a hub limited to two connections per user", t, func() {
+		h := NewHub(2, 2)
+
+		a, err := h.Join("alice", "", "10.0.0.1:1234", "websocket")
+		So(err, ShouldBeNil)
+		b, err := h.Join("alice", "", "10.0.0.2:1234", "sse")
+		So(err, ShouldBeNil)
+
+		Convey("each connection should get its own copy of every event", func() {
+			h.Publish([]byte("event"))
+			So(string(<-a.events), ShouldEqual, "event")
+			So(string(<-b.events), ShouldEqual, "event")
+		})
+
+		Convey("a third connection of the same user should be rejected", func() {
+			_, err := h.Join("alice", "", "10.0.0.3:1234", "websocket")
+			So(err, ShouldEqual, ErrTooManyConnections)
+
+			_, err = h.Join("bob", "", "10.0.0.3:1234", "websocket")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("a connection leaving should not affect the others", func() {
+			h.Leave(a)
+			h.Publish([]byte("event"))
+			So(string(<-b.events), ShouldEqual, "event")
+			So(len(h.List()), ShouldEqual, 1)
+
+			_, err := h.Join("alice", "", "10.0.0.3:1234", "websocket")
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a viewer that doesn't keep up", t, func() {
+		h := NewHub(0, 2)
+		v, _ := h.Join("alice", "", "10.0.0.1:1234", "websocket")
+
+		Convey("it should be evicted once its queue is full", func() {
+			for i := 0; i < 3; i++ {
+				h.Publish([]byte("event"))
+			}
+			So(len(h.List()), ShouldEqual, 0)
+			So(v.reason, ShouldEqual, slowConsumer)
+
+			<-v.events
+			<-v.events
+			_, ok := <-v.events
+			So(ok, ShouldBeFalse)
+		})
+	})
+}